@@ -1,7 +1,10 @@
 package cgroups
 
 import (
+	"errors"
+	"fmt"
 	"github.com/Sirupsen/logrus"
+	"os"
 	"ttdocker/cgroups/subsystems"
 )
 
@@ -16,6 +19,12 @@ type CgroupManager struct {
 
 func NewCgroupManager(path string) *CgroupManager {
 
+	//在启动时检测宿主机使用的是 cgroup v1 还是 v2
+	if subsystems.IsCgroup2UnifiedMode() {
+
+		logrus.Infof("cgroup v2 unified hierarchy detected")
+	}
+
 	return &CgroupManager{
 		Path:path,
 	}
@@ -26,33 +35,96 @@ func (c *CgroupManager)Apply(pid int) error {
 
 	for _, subSysIns := range(subsystems.SubsystemsIns){
 
-		_ = subSysIns.Apply(c.Path, pid)
+		if err := subSysIns.Apply(c.Path, pid); err != nil {
+
+			logrus.Warnf("apply cgroup %s fail %v", subSysIns.Name(), err)
+		}
 	}
 
 	return nil
 }
 
 // 设置cgroup资源限制  设置各个 subsystem 挂载中的 cgroup 资源限制
+// 某个 subsystem 设置失败时继续设置其余的 subsystem, 最后返回第一个错误
 func (c *CgroupManager)Set(res *subsystems.ResourceConfig) error {
 
+	var setErr error
 	for _, subSysIns := range (subsystems.SubsystemsIns){
 
-		subSysIns.Set(c.Path, res)
+		if err := subSysIns.Set(c.Path, res); err != nil {
+
+			logrus.Warnf("set cgroup %s fail %v", subSysIns.Name(), err)
+			if setErr == nil {
+				setErr = fmt.Errorf("set cgroup %s fail %v", subSysIns.Name(), err)
+			}
+		}
 	}
 
-	return nil
+	return setErr
+}
+
+// 冻结 cgroup 中的所有进程
+func (c *CgroupManager)Freeze() error {
+
+	freezer := &subsystems.FreezerSubSystem{}
+	return freezer.Freeze(c.Path, true)
+}
+
+// 恢复被冻结的进程
+func (c *CgroupManager)Thaw() error {
+
+	freezer := &subsystems.FreezerSubSystem{}
+	return freezer.Freeze(c.Path, false)
+}
+
+// 读取 cgroup 的资源使用统计, 没有挂载的 subsystem 对应的数据为 0
+func (c *CgroupManager)GetStats() (*subsystems.Stats, error) {
+
+	stats := &subsystems.Stats{}
+	for _, subSysIns := range(subsystems.SubsystemsIns) {
+
+		if statsIns, ok := subSysIns.(subsystems.StatsSubsystem); ok {
+
+			if err := statsIns.GetStats(c.Path, stats); err != nil {
+
+				logrus.Debugf("get cgroup %s stats fail %v", subSysIns.Name(), err)
+			}
+		}
+	}
+
+	return stats, nil
 }
 
 //　释放cgroup
+// 已经删除过的 cgroup 直接跳过, 所以容器退出、stop 和 rm 时都可以调用
 func (c *CgroupManager)Destroy() error {
 
+	//v2 下每个容器只有一个 cgroup 目录
+	if subsystems.IsCgroup2UnifiedMode() {
+
+		if err := subsystems.RemoveUnifiedCgroup(c.Path); err != nil {
+
+			logrus.Warnf("remove cgroup fail %v", err)
+			return err
+		}
+		return nil
+	}
+
+	var removeErr error
 	for _, subSysIns := range(subsystems.SubsystemsIns) {
 
 		if err := subSysIns.Remove(c.Path); err != nil {
 
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
 			logrus.Warnf("remove cgroup fail %v", err)
+			if removeErr == nil {
+				removeErr = fmt.Errorf("remove cgroup %s fail %v", subSysIns.Name(), err)
+			}
 		}
 	}
 
-	return nil
-}
\ No newline at end of file
+	return removeErr
+}
@@ -0,0 +1,214 @@
+package cgroups
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"ttdocker/cgroups/subsystems"
+)
+
+/*
+	在临时目录中搭建 v1 的 cgroup 文件系统, 每个 hierarchy 一个目录, 返回 subsystem 到目录的映射
+	cpu 和 cpuacct 和宿主机上一样挂载在同一个 hierarchy 上
+*/
+func fakeCgroupV1(t *testing.T, hierarchies ...string) map[string]string {
+
+	root := t.TempDir()
+	mountpoints := map[string]string{}
+	var lines []string
+	for _, hierarchy := range hierarchies {
+
+		mnt := path.Join(root, hierarchy)
+		if err := os.MkdirAll(mnt, 0755); err != nil {
+
+			t.Fatal(err)
+		}
+		lines = append(lines, "36 25 0:31 / " + mnt + " rw,nosuid,nodev,noexec,relatime shared:9 - cgroup cgroup rw," + hierarchy)
+		//和内核一样, cpuset 的根 cgroup 中包含宿主机全部的 cpu 和内存节点
+		if hierarchy == "cpuset" {
+
+			for file, content := range map[string]string{"cpuset.cpus": "0-3\n", "cpuset.mems": "0\n"} {
+
+				if err := ioutil.WriteFile(path.Join(mnt, file), []byte(content), 0644); err != nil {
+
+					t.Fatal(err)
+				}
+			}
+		}
+		for _, subsystem := range strings.Split(hierarchy, ",") {
+
+			mountpoints[subsystem] = mnt
+		}
+	}
+
+	subsystems.SetMountinfoReader(func() (io.ReadCloser, error) {
+
+		return ioutil.NopCloser(strings.NewReader(strings.Join(lines, "\n") + "\n")), nil
+	})
+	t.Cleanup(func() {
+
+		subsystems.SetMountinfoReader(func() (io.ReadCloser, error) {
+
+			return os.Open("/proc/self/mountinfo")
+		})
+	})
+
+	return mountpoints
+}
+
+func readFile(t *testing.T, file string) string {
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	return string(content)
+}
+
+//真正的 cgroup 目录中的文件由内核管理, 伪造的目录要先删掉其中的文件才能 rmdir
+func clearFiles(t *testing.T, dir string) {
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	for _, file := range files {
+
+		if !file.IsDir() {
+
+			os.Remove(path.Join(dir, file.Name()))
+		}
+	}
+}
+
+//--cgroup-parent 指定的父 cgroup 和容器 ID 拼成 cgroup 路径, 在每个 hierarchy 中逐级创建
+func TestCgroupManagerNested(t *testing.T) {
+
+	mountpoints := fakeCgroupV1(t, "cpuset", "memory", "cpu,cpuacct", "pids", "blkio", "freezer")
+	manager := NewCgroupManager("ttdocker.slice/1234567890")
+
+	res := &subsystems.ResourceConfig{
+		MemoryLimit: "104857600",
+		MemorySwap: "209715200",
+		CpuShare: "512",
+		CpuSet: "0",
+		CpuQuota: "50000",
+		CpuPeriod: "100000",
+		PidsLimit: "64",
+		BlkioWeight: "500",
+	}
+	if err := manager.Set(res); err != nil {
+
+		t.Fatalf("Set error %v", err)
+	}
+
+	for _, file := range []struct {
+		subsystem 	string
+		name 		string
+		want 		string
+	}{
+		{"memory", "memory.limit_in_bytes", "104857600"},
+		{"memory", "memory.memsw.limit_in_bytes", "209715200"},
+		{"cpu", "cpu.shares", "512"},
+		{"cpu", "cpu.cfs_period_us", "100000"},
+		{"cpu", "cpu.cfs_quota_us", "50000"},
+		{"cpuset", "cpuset.cpus", "0"},
+		{"cpuset", "cpuset.mems", "0\n"},
+		{"pids", "pids.max", "64"},
+		{"blkio", "blkio.weight", "500"},
+	} {
+
+		filePath := path.Join(mountpoints[file.subsystem], "ttdocker.slice", "1234567890", file.name)
+		if got := readFile(t, filePath); got != file.want {
+
+			t.Errorf("%s = %q, want %q", filePath, got, file.want)
+		}
+	}
+
+	//中间一级的父 cgroup 从根 cgroup 继承 cpuset
+	for file, want := range map[string]string{"cpuset.cpus": "0-3\n", "cpuset.mems": "0\n"} {
+
+		if got := readFile(t, path.Join(mountpoints["cpuset"], "ttdocker.slice", file)); got != want {
+
+			t.Errorf("ttdocker.slice/%s = %q, want %q", file, got, want)
+		}
+	}
+
+	if err := manager.Apply(4321); err != nil {
+
+		t.Fatalf("Apply error %v", err)
+	}
+	for _, subsystem := range []string{"cpuset", "memory", "cpu", "pids", "blkio", "freezer"} {
+
+		tasks := path.Join(mountpoints[subsystem], "ttdocker.slice", "1234567890", "tasks")
+		if got := readFile(t, tasks); got != "4321" {
+
+			t.Errorf("%s = %q, want 4321", tasks, got)
+		}
+	}
+
+	//只删除容器自己的 cgroup, 父 cgroup 留给其他容器使用
+	for _, mnt := range mountpoints {
+
+		clearFiles(t, path.Join(mnt, "ttdocker.slice", "1234567890"))
+	}
+	if err := manager.Destroy(); err != nil {
+
+		t.Fatalf("Destroy error %v", err)
+	}
+	for subsystem, mnt := range mountpoints {
+
+		if _, err := os.Stat(path.Join(mnt, "ttdocker.slice", "1234567890")); !os.IsNotExist(err) {
+
+			t.Errorf("%s cgroup still exists after destroy: %v", subsystem, err)
+		}
+		if _, err := os.Stat(path.Join(mnt, "ttdocker.slice")); err != nil {
+
+			t.Errorf("%s parent cgroup removed: %v", subsystem, err)
+		}
+	}
+
+	//重复释放不报错
+	if err := manager.Destroy(); err != nil {
+
+		t.Errorf("destroy destroyed cgroup error %v", err)
+	}
+}
+
+//宿主机没有挂载的 subsystem 设置时报错, 释放时跳过
+func TestCgroupManagerUnmountedSubsystem(t *testing.T) {
+
+	mountpoints := fakeCgroupV1(t, "memory", "cpu,cpuacct")
+	manager := NewCgroupManager("test")
+
+	err := manager.Set(&subsystems.ResourceConfig{MemoryLimit: "1048576"})
+	if err == nil || !strings.Contains(err.Error(), "not mounted") {
+
+		t.Errorf("Set error = %v, want subsystem not mounted", err)
+	}
+	if got := readFile(t, path.Join(mountpoints["memory"], "test", "memory.limit_in_bytes")); got != "1048576" {
+
+		t.Errorf("memory.limit_in_bytes = %q, want 1048576", got)
+	}
+
+	for _, subsystem := range []string{"memory", "cpu"} {
+
+		clearFiles(t, path.Join(mountpoints[subsystem], "test"))
+	}
+	if err := manager.Destroy(); err != nil {
+
+		t.Errorf("Destroy error %v", err)
+	}
+
+	if _, err := subsystems.GetCgroupPath("pids", "test", false); !errors.Is(err, os.ErrNotExist) {
+
+		t.Errorf("GetCgroupPath of unmounted subsystem error = %v, want ErrNotExist", err)
+	}
+}
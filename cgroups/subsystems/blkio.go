@@ -0,0 +1,269 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+//blkio 子系统, 限制容器对块设备的读写速率和 IO 权重
+//v1 中对应 blkio 控制器, v2 中对应 io 控制器
+type BlkioSubSystem struct {
+
+}
+
+//一条设备限速规则, 对应命令行中的 <设备路径>:<速率>
+type deviceThrottle struct {
+	device string // major:minor 形式的设备号
+	rate   uint64
+}
+
+func (s *BlkioSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+
+		if IsCgroup2UnifiedMode() {
+
+			return s.setUnified(subsysCgroupPath, res)
+		}
+
+		if res.BlkioWeight != "" {
+
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "blkio.weight"), []byte(res.BlkioWeight), 0644); err != nil {
+
+				return fmt.Errorf("set cgroup blkio weight fail %v", err)
+			}
+		}
+
+		//v1 中每种限速各自对应一个文件, 每次写入一个设备的 "major:minor rate"
+		throttleFiles := map[string][]string{
+			"blkio.throttle.read_bps_device":   res.BlkioDeviceReadBps,
+			"blkio.throttle.write_bps_device":  res.BlkioDeviceWriteBps,
+			"blkio.throttle.read_iops_device":  res.BlkioDeviceReadIops,
+			"blkio.throttle.write_iops_device": res.BlkioDeviceWriteIops,
+		}
+		for file, rules := range throttleFiles {
+
+			throttles, err := parseDeviceThrottles(rules)
+			if err != nil {
+
+				return err
+			}
+			for _, t := range throttles {
+
+				line := fmt.Sprintf("%s %d", t.device, t.rate)
+				if err := ioutil.WriteFile(path.Join(subsysCgroupPath, file), []byte(line), 0644); err != nil {
+
+					return fmt.Errorf("set cgroup %s %s fail %v", file, line, err)
+				}
+			}
+		}
+		return nil
+	} else {
+
+		return err
+	}
+}
+
+//v2 中权重写入 io.weight, 同一设备的四种限速合并成一行写入 io.max
+//例如 echo "8:0 rbps=1048576 wiops=100" > io.max
+func (s *BlkioSubSystem) setUnified(subsysCgroupPath string, res *ResourceConfig) error {
+
+	if res.BlkioWeight != "" {
+
+		weight, err := strconv.ParseUint(res.BlkioWeight, 10, 64)
+		if err != nil || weight < 10 || weight > 1000 {
+
+			return fmt.Errorf("invalid blkio weight %s, range is [10, 1000]", res.BlkioWeight)
+		}
+		//把 v1 的 [10, 1000] 映射到 v2 的 [1, 10000]
+		ioWeight := fmt.Sprintf("default %d", 1+(weight-10)*9999/990)
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "io.weight"), []byte(ioWeight), 0644); err != nil {
+
+			return fmt.Errorf("set cgroup io weight fail %v", err)
+		}
+	}
+
+	limits := map[string][]string{}
+	var devices []string
+	keys := []string{"rbps", "wbps", "riops", "wiops"}
+	rules := [][]string{res.BlkioDeviceReadBps, res.BlkioDeviceWriteBps, res.BlkioDeviceReadIops, res.BlkioDeviceWriteIops}
+
+	for i, key := range keys {
+
+		throttles, err := parseDeviceThrottles(rules[i])
+		if err != nil {
+
+			return err
+		}
+		for _, t := range throttles {
+
+			if _, ok := limits[t.device]; !ok {
+
+				devices = append(devices, t.device)
+			}
+			limits[t.device] = append(limits[t.device], fmt.Sprintf("%s=%d", key, t.rate))
+		}
+	}
+
+	for _, device := range devices {
+
+		line := device + " " + strings.Join(limits[device], " ")
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "io.max"), []byte(line), 0644); err != nil {
+
+			return fmt.Errorf("set cgroup io.max %s fail %v", line, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *BlkioSubSystem) Apply(cgroupPath string, pid int) error {
+
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, cgroupProcsFile()), []byte(strconv.Itoa(pid)), 0644); err != nil {
+
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *BlkioSubSystem) Remove(cgroupPath string) error {
+
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+
+		return removeCgroupDir(subsysCgroupPath)
+	} else {
+
+		return err
+	}
+}
+
+//统计所有块设备上累计读写的字节数
+//v1 中 blkio.throttle.io_service_bytes 每行为 "8:0 Read 4096", v2 中 io.stat 每行为 "8:0 rbytes=4096 wbytes=0 ..."
+func (s *BlkioSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+
+		return err
+	}
+
+	statFile := "blkio.throttle.io_service_bytes"
+	if IsCgroup2UnifiedMode() {
+
+		statFile = "io.stat"
+	}
+
+	content, err := ioutil.ReadFile(path.Join(subsysCgroupPath, statFile))
+	if err != nil {
+
+		return fmt.Errorf("read %s error %v", statFile, err)
+	}
+
+	stats.BlkioRead, stats.BlkioWrite = 0, 0
+	for _, line := range strings.Split(string(content), "\n") {
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		if IsCgroup2UnifiedMode() {
+
+			for _, kv := range fields[1:] {
+
+				pair := strings.SplitN(kv, "=", 2)
+				if len(pair) != 2 {
+					continue
+				}
+				value, _ := strconv.ParseUint(pair[1], 10, 64)
+				switch pair[0] {
+				case "rbytes":
+					stats.BlkioRead += value
+				case "wbytes":
+					stats.BlkioWrite += value
+				}
+			}
+			continue
+		}
+
+		if len(fields) != 3 {
+			continue
+		}
+		value, _ := strconv.ParseUint(fields[2], 10, 64)
+		switch fields[1] {
+		case "Read":
+			stats.BlkioRead += value
+		case "Write":
+			stats.BlkioWrite += value
+		}
+	}
+
+	return nil
+}
+
+func (s *BlkioSubSystem) Name() string {
+
+	return "blkio"
+}
+
+//解析 /dev/sda:1048576 形式的限速规则, 把设备路径转换成 major:minor 设备号
+func parseDeviceThrottles(rules []string) ([]deviceThrottle, error) {
+
+	var throttles []deviceThrottle
+	for _, rule := range rules {
+
+		index := strings.LastIndex(rule, ":")
+		if index <= 0 {
+
+			return nil, fmt.Errorf("invalid device throttle %s, format is <device>:<rate>", rule)
+		}
+
+		rate, err := strconv.ParseUint(rule[index+1:], 10, 64)
+		if err != nil {
+
+			return nil, fmt.Errorf("invalid rate in device throttle %s", rule)
+		}
+
+		device, err := deviceNumber(rule[:index])
+		if err != nil {
+
+			return nil, err
+		}
+
+		throttles = append(throttles, deviceThrottle{device: device, rate: rate})
+	}
+
+	return throttles, nil
+}
+
+//通过 stat 得到块设备的设备号, 格式为 major:minor
+func deviceNumber(devicePath string) (string, error) {
+
+	var stat syscall.Stat_t
+	if err := syscall.Stat(devicePath, &stat); err != nil {
+
+		return "", fmt.Errorf("stat device %s error %v", devicePath, err)
+	}
+
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+
+		return "", fmt.Errorf("%s is not a block device", devicePath)
+	}
+
+	//与 glibc 中 major() / minor() 宏的计算方式一致
+	rdev := uint64(stat.Rdev)
+	major := ((rdev >> 8) & 0xfff) | ((rdev >> 32) &^ 0xfff)
+	minor := (rdev & 0xff) | ((rdev >> 12) &^ 0xff)
+
+	return fmt.Sprintf("%d:%d", major, minor), nil
+}
@@ -3,7 +3,6 @@ package subsystems
 import (
 	"fmt"
 	"io/ioutil"
-	"os"
 	"path"
 	"strconv"
 )
@@ -16,12 +15,26 @@ func (s *CpuSubSystem)Set(cgroupPath string, res *ResourceConfig) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err ==nil {
 
 		if res.CpuShare != "" {
-			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.shares"), []byte(res.CpuShare), 0644); err != nil {
 
-				return fmt.Errorf("set cgroup cpu share fail #{err}")
+			//v2 中没有 cpu.shares, 换算成 cpu.weight 写入
+			if IsCgroup2UnifiedMode() {
+
+				weight, err := sharesToWeight(res.CpuShare)
+				if err != nil {
+
+					return err
+				}
+				if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.weight"), []byte(weight), 0644); err != nil {
+
+					return fmt.Errorf("set cgroup cpu weight fail %v", err)
+				}
+			} else if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.shares"), []byte(res.CpuShare), 0644); err != nil {
+
+				return fmt.Errorf("set cgroup cpu share fail %v", err)
 			}
 		}
-		return nil
+
+		return s.setQuota(subsysCgroupPath, res)
 	}else {
 
 		return err
@@ -34,7 +47,7 @@ func (s *CpuSubSystem)Remove(cgroupPath string) error {
 
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
 
-		return os.RemoveAll(subsysCgroupPath)
+		return removeCgroupDir(subsysCgroupPath)
 	}else {
 		return err
 	}
@@ -45,9 +58,9 @@ func (s *CpuSubSystem)Apply(cgroupPath string, pid int) error {
 
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
 
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, cgroupProcsFile()), []byte(strconv.Itoa(pid)), 0644); err != nil {
 
-			return fmt.Errorf("set cgroup proc fail #{err}")
+			return fmt.Errorf("set cgroup proc fail %v", err)
 		}
 		return nil
 	}else{
@@ -57,6 +70,106 @@ func (s *CpuSubSystem)Apply(cgroupPath string, pid int) error {
 
 
 
+//设置 CPU 时间的硬上限, 每个 period 内最多可以使用 quota 的 CPU 时间
+//v1 写入 cpu.cfs_period_us 和 cpu.cfs_quota_us, v2 写入 cpu.max, 格式为 "<quota> <period>"
+func (s *CpuSubSystem) setQuota(subsysCgroupPath string, res *ResourceConfig) error {
+
+	if res.CpuQuota == "" && res.CpuPeriod == "" {
+
+		return nil
+	}
+
+	if IsCgroup2UnifiedMode() {
+
+		quota := unifiedLimit(res.CpuQuota)
+		if quota == "" {
+			quota = "max"
+		}
+		period := res.CpuPeriod
+		if period == "" {
+			period = strconv.Itoa(defaultCpuPeriod)
+		}
+
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.max"), []byte(quota+" "+period), 0644); err != nil {
+
+			return fmt.Errorf("set cgroup cpu max fail %v", err)
+		}
+		return nil
+	}
+
+	//先写 period 再写 quota, 避免新的 quota 和旧的 period 组合不合法
+	if res.CpuPeriod != "" {
+
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_period_us"), []byte(res.CpuPeriod), 0644); err != nil {
+
+			return fmt.Errorf("set cgroup cpu period fail %v", err)
+		}
+	}
+	if res.CpuQuota != "" {
+
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_quota_us"), []byte(res.CpuQuota), 0644); err != nil {
+
+			return fmt.Errorf("set cgroup cpu quota fail %v", err)
+		}
+	}
+
+	return nil
+}
+
+//把 v1 的 cpu.shares [2, 262144] 线性映射到 v2 的 cpu.weight [1, 10000]
+func sharesToWeight(shares string) (string, error) {
+
+	value, err := strconv.ParseUint(shares, 10, 64)
+	if err != nil {
+
+		return "", fmt.Errorf("invalid cpu share %s %v", shares, err)
+	}
+	if value < 2 {
+		value = 2
+	}
+	if value > 262144 {
+		value = 262144
+	}
+
+	return strconv.FormatUint(1+((value-2)*9999)/262142, 10), nil
+}
+
+//读取容器累计使用的 CPU 时间
+//v1 中统计数据在 cpuacct 子系统的 cpuacct.usage 中, 单位纳秒; v2 中在 cpu.stat 的 usage_usec 中, 单位微秒
+func (s *CpuSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+
+	if IsCgroup2UnifiedMode() {
+
+		subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+		if err != nil {
+
+			return err
+		}
+		cpuStat, err := readKeyValues(path.Join(subsysCgroupPath, "cpu.stat"))
+		if err != nil {
+
+			return fmt.Errorf("read cpu.stat error %v", err)
+		}
+		stats.CpuUsage = cpuStat["usage_usec"] * 1000
+		return nil
+	}
+
+	//cpu 和 cpuacct 一般挂载在同一个 hierarchy 上, 进程加入 cpu 的 cgroup 时也加入了 cpuacct
+	subsysCgroupPath, err := GetCgroupPath("cpuacct", cgroupPath, false)
+	if err != nil {
+
+		return err
+	}
+	usage, err := readUint(path.Join(subsysCgroupPath, "cpuacct.usage"))
+	if err != nil {
+
+		return fmt.Errorf("read cpuacct.usage error %v", err)
+	}
+
+	stats.CpuUsage = usage
+	return nil
+}
+
 func (s *CpuSubSystem)Name() string {
 
 	return "cpu"
@@ -0,0 +1,141 @@
+package subsystems
+
+import (
+	"path"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestValidateCpuQuota(t *testing.T) {
+
+	//配额的上限和宿主机的 CPU 个数有关, 按当前机器计算
+	hostCpus := runtime.NumCPU()
+	maxQuota := strconv.Itoa(defaultCpuPeriod * hostCpus)
+
+	tests := []struct {
+		name 		string
+		cpus 		string
+		quota 		string
+		period 		string
+		wantQuota 	string
+		wantPeriod 	string
+		wantErr 	bool
+	}{
+		{"nothing", "", "", "", "", "", false},
+		{"half cpu", "0.5", "", "", "50000", "100000", false},
+		{"all cpus", strconv.Itoa(hostCpus), "", "", maxQuota, "100000", false},
+		{"cpus with the same quota", "0.5", "50000", "100000", "50000", "100000", false},
+		{"cpus with another quota", "0.5", "20000", "", "", "", true},
+		{"cpus with another period", "0.5", "", "50000", "", "", true},
+		{"zero cpus", "0", "", "", "", "", true},
+		{"negative cpus", "-1", "", "", "", "", true},
+		{"cpus not a number", "half", "", "", "", "", true},
+		{"more cpus than host", strconv.Itoa(hostCpus + 1), "", "", "", "", true},
+		{"quota only", "", "50000", "", "50000", "", false},
+		{"unlimited quota", "", "-1", "", "-1", "", false},
+		{"quota too small", "", "999", "", "", "", true},
+		{"quota not a number", "", "1e5", "", "", "", true},
+		{"quota larger than host", "", strconv.Itoa(defaultCpuPeriod*hostCpus + 1), "", "", "", true},
+		{"quota with longer period", "", strconv.Itoa(1000000 * hostCpus), "1000000", strconv.Itoa(1000000 * hostCpus), "1000000", false},
+		{"min period", "", "", "1000", "", "1000", false},
+		{"max period", "", "", "1000000", "", "1000000", false},
+		{"period too small", "", "", "999", "", "", true},
+		{"period too large", "", "", "1000001", "", "", true},
+		{"negative period", "", "", "-1", "", "", true},
+		{"quota exceeds short period", "", strconv.Itoa(1000*hostCpus + 1), "1000", "", "", true},
+	}
+
+	for _, test := range tests {
+
+		res := &ResourceConfig{Cpus: test.cpus, CpuQuota: test.quota, CpuPeriod: test.period}
+		err := res.validateCpuQuota()
+		if test.wantErr {
+
+			if err == nil {
+
+				t.Errorf("%s: expect error, got quota %q period %q", test.name, res.CpuQuota, res.CpuPeriod)
+			}
+			continue
+		}
+		if err != nil {
+
+			t.Errorf("%s: validateCpuQuota() error %v", test.name, err)
+			continue
+		}
+		if res.CpuQuota != test.wantQuota || res.CpuPeriod != test.wantPeriod {
+
+			t.Errorf("%s: quota %q period %q, want %q %q", test.name, res.CpuQuota, res.CpuPeriod, test.wantQuota, test.wantPeriod)
+		}
+	}
+}
+
+func TestSharesToWeight(t *testing.T) {
+
+	tests := []struct {
+		shares 	string
+		weight 	string
+		wantErr bool
+	}{
+		{"2", "1", false},
+		{"512", "20", false},
+		{"1024", "39", false},
+		{"262144", "10000", false},
+		//超出 cpu.shares 范围的值先截断
+		{"0", "1", false},
+		{"1000000", "10000", false},
+		{"-1", "", true},
+		{"1.5", "", true},
+		{"", "", true},
+	}
+
+	for _, test := range tests {
+
+		weight, err := sharesToWeight(test.shares)
+		if (err != nil) != test.wantErr || weight != test.weight {
+
+			t.Errorf("sharesToWeight(%q) = %q, %v, want %q", test.shares, weight, err, test.weight)
+		}
+	}
+}
+
+//--cpus 换算出来的 quota 和 period 在 v2 中合并写入 cpu.max
+func TestUnifiedCpuMax(t *testing.T) {
+
+	root := fakeUnifiedRoot(t, "cpu")
+
+	tests := []struct {
+		res 	ResourceConfig
+		want 	string
+	}{
+		{ResourceConfig{Cpus: "0.5"}, "50000 100000"},
+		{ResourceConfig{CpuQuota: "-1"}, "max 100000"},
+		{ResourceConfig{CpuPeriod: "50000"}, "max 50000"},
+		{ResourceConfig{CpuQuota: "20000", CpuPeriod: "50000"}, "20000 50000"},
+	}
+
+	for _, test := range tests {
+
+		if err := test.res.Validate(); err != nil {
+
+			t.Fatal(err)
+		}
+		if err := (&CpuSubSystem{}).Set("test", &test.res); err != nil {
+
+			t.Fatal(err)
+		}
+		if got := readFile(t, path.Join(root, "test", "cpu.max")); got != test.want {
+
+			t.Errorf("%+v: cpu.max = %q, want %q", test.res, got, test.want)
+		}
+	}
+
+	if err := (&CpuSubSystem{}).Set("test", &ResourceConfig{CpuShare: "2"}); err != nil {
+
+		t.Fatal(err)
+	}
+	if got := readFile(t, path.Join(root, "test", "cpu.weight")); got != "1" {
+
+		t.Errorf("cpu.weight = %q, want 1", got)
+	}
+}
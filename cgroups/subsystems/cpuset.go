@@ -3,9 +3,9 @@ package subsystems
 import (
 	"fmt"
 	"io/ioutil"
-	"os"
 	"path"
 	"strconv"
+	"strings"
 )
 
 type CpusetSubSystem struct {
@@ -21,7 +21,7 @@ func (s *CpusetSubSystem)Set(cgroupPath string, res *ResourceConfig) error {
 
 			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpuset.cpus"), []byte(res.CpuSet), 0644); err != nil {
 
-				return fmt.Errorf("set cgroup cpuset fail #{err}")
+				return fmt.Errorf("set cgroup cpuset fail %v", err)
 			}
 		}
 		return nil
@@ -35,9 +35,9 @@ func (s *CpusetSubSystem)Set(cgroupPath string, res *ResourceConfig) error {
 
 func (s *CpusetSubSystem)Remove(cgroupPath string) error {
 
-	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err != nil {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
 
-		return os.RemoveAll(subsysCgroupPath)
+		return removeCgroupDir(subsysCgroupPath)
 	} else {
 
 		return err
@@ -50,9 +50,9 @@ func (s *CpusetSubSystem)Apply(cgroupPath string, pid int) error {
 
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
 
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, cgroupProcsFile()), []byte(strconv.Itoa(pid)), 0644); err != nil {
 
-			return fmt.Errorf("set cgroup proc fail #{err}")
+			return fmt.Errorf("set cgroup proc fail %v", err)
 		}
 		return nil
 	}else {
@@ -66,4 +66,27 @@ func (s *CpusetSubSystem)Apply(cgroupPath string, pid int) error {
 func (s *CpusetSubSystem) Name() string {
 
 	return "cpuset"
-}
\ No newline at end of file
+}
+
+//把父 cgroup 的 cpuset.cpus 和 cpuset.mems 复制到新建的 cgroup 中, 已经有值的不覆盖
+func inheritCpuset(parent string, dir string) error {
+
+	for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+
+		if content, err := ioutil.ReadFile(path.Join(dir, file)); err == nil && strings.TrimSpace(string(content)) != "" {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(path.Join(parent, file))
+		if err != nil {
+
+			return fmt.Errorf("read %s of parent cgroup error %v", file, err)
+		}
+		if err := ioutil.WriteFile(path.Join(dir, file), content, 0644); err != nil {
+
+			return fmt.Errorf("inherit %s from parent cgroup error %v", file, err)
+		}
+	}
+
+	return nil
+}
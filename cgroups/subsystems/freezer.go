@@ -0,0 +1,125 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//freezer 子系统, 可以挂起(冻结)和恢复 cgroup 中的所有进程
+//v1 中通过 freezer.state 控制, v2 中 cgroup.freeze 是每个 cgroup 自带的接口, 不需要开启控制器
+type FreezerSubSystem struct {
+
+}
+
+//freezer 没有资源限制, 这里只是在 v1 中创建 freezer hierarchy 下的 cgroup 目录, 以便后面把进程加入进来
+func (s *FreezerSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+
+	if IsCgroup2UnifiedMode() {
+
+		return nil
+	}
+
+	_, err := GetCgroupPath(s.Name(), cgroupPath, true)
+	return err
+}
+
+func (s *FreezerSubSystem) Apply(cgroupPath string, pid int) error {
+
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, cgroupProcsFile()), []byte(strconv.Itoa(pid)), 0644); err != nil {
+
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *FreezerSubSystem) Remove(cgroupPath string) error {
+
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+
+		return removeCgroupDir(subsysCgroupPath)
+	} else {
+
+		return err
+	}
+}
+
+//冻结或者恢复 cgroup 中的所有进程, 并等待内核完成状态切换
+func (s *FreezerSubSystem) Freeze(cgroupPath string, frozen bool) error {
+
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+
+		return err
+	}
+
+	//v1 写入 FROZEN / THAWED, 切换过程中 freezer.state 会显示 FREEZING
+	stateFile, state := "freezer.state", "THAWED"
+	if frozen {
+		state = "FROZEN"
+	}
+	//v2 写入 1 / 0, 切换完成后 cgroup.events 中的 frozen 字段会变成对应的值
+	if IsCgroup2UnifiedMode() {
+
+		stateFile, state = "cgroup.freeze", "0"
+		if frozen {
+			state = "1"
+		}
+	}
+
+	if err := ioutil.WriteFile(path.Join(subsysCgroupPath, stateFile), []byte(state), 0644); err != nil {
+
+		return fmt.Errorf("write %s error %v", stateFile, err)
+	}
+
+	for i := 0; i < 100; i++ {
+
+		current, err := s.currentState(subsysCgroupPath)
+		if err != nil {
+
+			return err
+		}
+		if current == state {
+
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return fmt.Errorf("timeout waiting for cgroup %s to change freezer state to %s", cgroupPath, state)
+}
+
+func (s *FreezerSubSystem) currentState(subsysCgroupPath string) (string, error) {
+
+	if IsCgroup2UnifiedMode() {
+
+		events, err := readKeyValues(path.Join(subsysCgroupPath, "cgroup.events"))
+		if err != nil {
+
+			return "", fmt.Errorf("read cgroup.events error %v", err)
+		}
+		return strconv.FormatUint(events["frozen"], 10), nil
+	}
+
+	content, err := ioutil.ReadFile(path.Join(subsysCgroupPath, "freezer.state"))
+	if err != nil {
+
+		return "", fmt.Errorf("read freezer.state error %v", err)
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
+
+func (s *FreezerSubSystem) Name() string {
+
+	return "freezer"
+}
@@ -2,10 +2,12 @@ package subsystems
 
 import (
 	"fmt"
+	"github.com/Sirupsen/logrus"
 	"io/ioutil"
-	"os"
+	"math"
 	"path"
 	"strconv"
+	"strings"
 )
 
 type MemorySubSystem struct {
@@ -24,9 +26,41 @@ func (s *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 
 			//writefile 函数向filename指定的文件中写入数据。如果文件不存在将按给出的权限创建文件，否则在写入数据之前清空文件。
 			// Join 讲任意数量的路径元素放入一个单一路径里 sbusysCgroupPath/memory.limit_in_bytes
-			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.limit_in_bytes"), []byte(res.MemoryLimit), 0644); err != nil {
+			//v2 中对应的文件是 memory.max
+			if IsCgroup2UnifiedMode() {
 
-				return fmt.Errorf("set cgrup memory fail #{err}")
+				if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.max"), []byte(unifiedLimit(res.MemoryLimit)), 0644); err != nil {
+
+					return fmt.Errorf("set cgroup memory fail %v", err)
+				}
+			}
+		}
+
+		if IsCgroup2UnifiedMode() {
+
+			return s.setUnified(subsysCgroupPath, res)
+		}
+
+		if err := s.setLimitAndSwap(subsysCgroupPath, res); err != nil {
+
+			return err
+		}
+
+		//内存软限制, 宿主机内存紧张时, 内核会尽量把容器的内存回收到这个值以下
+		if res.MemoryReservation != "" {
+
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.soft_limit_in_bytes"), []byte(res.MemoryReservation), 0644); err != nil {
+
+				return fmt.Errorf("set cgroup memory reservation fail %v", err)
+			}
+		}
+
+		//向 memory.oom_control 写入 1 关闭 OOM killer, 超出限制的进程会被挂起而不是被杀掉
+		if res.OomKillDisable {
+
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.oom_control"), []byte("1"), 0644); err != nil {
+
+				return fmt.Errorf("set cgroup oom kill disable fail %v", err)
 			}
 		}
 
@@ -37,21 +71,172 @@ func (s *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	}
 }
 
+/*
+	v1 中内核要求 memory.memsw.limit_in_bytes 始终不小于 memory.limit_in_bytes, 否则写入返回 EINVAL
+	1.调大内存限制时先写 memsw, 再写 limit
+	2.调小内存限制时先写 limit, 再写 memsw
+*/
+func (s *MemorySubSystem) setLimitAndSwap(subsysCgroupPath string, res *ResourceConfig) error {
+
+	writeLimit := func() error {
+
+		if res.MemoryLimit == "" {
+			return nil
+		}
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.limit_in_bytes"), []byte(res.MemoryLimit), 0644); err != nil {
+
+			return fmt.Errorf("set cgroup memory fail %v", err)
+		}
+		return nil
+	}
+	//内存加 swap 的总限制
+	writeSwap := func() error {
+
+		if res.MemorySwap == "" {
+			return nil
+		}
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.memsw.limit_in_bytes"), []byte(res.MemorySwap), 0644); err != nil {
+
+			return fmt.Errorf("set cgroup memory swap fail %v", err)
+		}
+		return nil
+	}
+
+	if res.MemoryLimit != "" && res.MemorySwap != "" && memoryLimitValue(res.MemoryLimit) > currentMemoryLimit(subsysCgroupPath) {
+
+		if err := writeSwap(); err != nil {
+
+			return err
+		}
+		return writeLimit()
+	}
+
+	if err := writeLimit(); err != nil {
+
+		return err
+	}
+	return writeSwap()
+}
+
+//读取 cgroup 当前的内存限制, 读取失败时按照没有限制处理, 这时先写 limit
+func currentMemoryLimit(subsysCgroupPath string) int64 {
+
+	content, err := ioutil.ReadFile(path.Join(subsysCgroupPath, "memory.limit_in_bytes"))
+	if err != nil {
+
+		return math.MaxInt64
+	}
+
+	return memoryLimitValue(strings.TrimSpace(string(content)))
+}
+
+//-1 表示不限制, 比任何限制都大
+func memoryLimitValue(value string) int64 {
+
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 0 {
+
+		return math.MaxInt64
+	}
+
+	return limit
+}
+
+//v2 中 swap 单独限制, 写入 memory.swap.max 的是 swap 部分的大小, 软限制对应 memory.low
+func (s *MemorySubSystem) setUnified(subsysCgroupPath string, res *ResourceConfig) error {
+
+	if res.MemorySwap != "" {
+
+		swap := res.MemorySwap
+		if swap != "-1" {
+
+			total, _ := strconv.ParseInt(res.MemorySwap, 10, 64)
+			limit, _ := strconv.ParseInt(res.MemoryLimit, 10, 64)
+			swap = strconv.FormatInt(total-limit, 10)
+		}
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.swap.max"), []byte(unifiedLimit(swap)), 0644); err != nil {
+
+			return fmt.Errorf("set cgroup memory swap fail %v", err)
+		}
+	}
+
+	if res.MemoryReservation != "" {
+
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.low"), []byte(res.MemoryReservation), 0644); err != nil {
+
+			return fmt.Errorf("set cgroup memory reservation fail %v", err)
+		}
+	}
+
+	//v2 中没有关闭 OOM killer 的接口
+	if res.OomKillDisable {
+
+		logrus.Warnf("oom kill disable is not supported by cgroup v2, ignored")
+	}
+
+	return nil
+}
+
+//v2 中不限制写的是 max 而不是 -1
+func unifiedLimit(value string) string {
+
+	if value == "-1" {
+
+		return "max"
+	}
+
+	return value
+}
+
+//根据 memory.oom_control(v1) 或 memory.events(v2) 中的 oom_kill 计数判断容器内是否有进程因为 OOM 被杀掉
+func (s *MemorySubSystem) OOMKilled(cgroupPath string) (bool, error) {
+
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+
+		return false, err
+	}
+
+	eventsFile := "memory.oom_control"
+	if IsCgroup2UnifiedMode() {
+
+		eventsFile = "memory.events"
+	}
+
+	content, err := ioutil.ReadFile(path.Join(subsysCgroupPath, eventsFile))
+	if err != nil {
+
+		return false, fmt.Errorf("read %s error %v", eventsFile, err)
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+
+			count, _ := strconv.Atoi(fields[1])
+			return count > 0, nil
+		}
+	}
+
+	return false, nil
+}
+
 //将一个迸程加入到 cgroupPath 对应的 cgroup 中
 func (s *MemorySubSystem) Apply(cgroupPath string, pid int) error {
 
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
 
 		//把进程的pid 写到cgroup 的虚拟文件系统对应目录下的 task 文件中
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, cgroupProcsFile()), []byte(strconv.Itoa(pid)), 0644); err != nil {
 
-			return fmt.Errorf("set group proc fail #{err}")
+			return fmt.Errorf("set group proc fail %v", err)
 		}
 
 		return nil
 	}else {
 
-		return fmt.Errorf("get cgroup #{cgroupPath} error :#{err}")
+		return fmt.Errorf("get cgroup %s error :%v", cgroupPath, err)
 	}
 }
 
@@ -61,13 +246,52 @@ func (s *MemorySubSystem) Remove(cgroupPath string) error {
 	if subsysCgoupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
 
 		//删除cgroup 便是删除对应的cgroupPath目录
-		return os.RemoveAll(subsysCgoupPath)
+		return removeCgroupDir(subsysCgoupPath)
 	}else {
 
 		return err
 	}
 }
 
+//读取内存使用量和限制, 使用量减去了可以回收的 inactive_file, 与 docker stats 的算法一致
+func (s *MemorySubSystem) GetStats(cgroupPath string, stats *Stats) error {
+
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+
+		return err
+	}
+
+	usageFile, limitFile, inactiveKey := "memory.usage_in_bytes", "memory.limit_in_bytes", "total_inactive_file"
+	if IsCgroup2UnifiedMode() {
+
+		usageFile, limitFile, inactiveKey = "memory.current", "memory.max", "inactive_file"
+	}
+
+	usage, err := readUint(path.Join(subsysCgroupPath, usageFile))
+	if err != nil {
+
+		return fmt.Errorf("read %s error %v", usageFile, err)
+	}
+	limit, err := readUint(path.Join(subsysCgroupPath, limitFile))
+	if err != nil {
+
+		return fmt.Errorf("read %s error %v", limitFile, err)
+	}
+
+	if memoryStat, err := readKeyValues(path.Join(subsysCgroupPath, "memory.stat")); err == nil {
+
+		if inactive := memoryStat[inactiveKey]; inactive < usage {
+
+			usage -= inactive
+		}
+	}
+
+	stats.MemoryUsage = usage
+	stats.MemoryLimit = limit
+	return nil
+}
+
 //返回 cgroup 的名字
 func (s *MemorySubSystem) Name() string {
 
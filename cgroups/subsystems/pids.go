@@ -0,0 +1,94 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+)
+
+//pids 子系统, 限制 cgroup 中最多能创建的进程数, 防止容器内的 fork 炸弹拖垮宿主机
+type PidsSubSystem struct {
+
+}
+
+//把进程数限制写入 pids.max, v1 和 v2 中文件名相同
+func (s *PidsSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+
+		if res.PidsLimit != "" {
+
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "pids.max"), []byte(res.PidsLimit), 0644); err != nil {
+
+				return fmt.Errorf("set cgroup pids limit fail %v", err)
+			}
+		}
+		return nil
+	} else {
+
+		return err
+	}
+}
+
+func (s *PidsSubSystem) Apply(cgroupPath string, pid int) error {
+
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, cgroupProcsFile()), []byte(strconv.Itoa(pid)), 0644); err != nil {
+
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *PidsSubSystem) Remove(cgroupPath string) error {
+
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+
+		return removeCgroupDir(subsysCgroupPath)
+	} else {
+
+		return err
+	}
+}
+
+//读取 pids.current, 得到 cgroup 中当前的进程数
+func (s *PidsSubSystem) Current(cgroupPath string) (int, error) {
+
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+
+		return 0, err
+	}
+
+	content, err := ioutil.ReadFile(path.Join(subsysCgroupPath, "pids.current"))
+	if err != nil {
+
+		return 0, fmt.Errorf("read pids.current error %v", err)
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
+
+func (s *PidsSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+
+	current, err := s.Current(cgroupPath)
+	if err != nil {
+
+		return err
+	}
+
+	stats.PidsCurrent = uint64(current)
+	return nil
+}
+
+func (s *PidsSubSystem) Name() string {
+
+	return "pids"
+}
@@ -0,0 +1,77 @@
+package subsystems
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+//从 cgroup 的统计文件中读取到的资源使用情况
+type Stats struct {
+	CpuUsage    uint64 `json:"cpuUsage"`    //累计使用的 CPU 时间, 单位纳秒
+	MemoryUsage uint64 `json:"memoryUsage"` //不包含可以回收的 page cache
+	MemoryLimit uint64 `json:"memoryLimit"` //0 表示没有限制
+	PidsCurrent uint64 `json:"pidsCurrent"`
+	BlkioRead   uint64 `json:"blkioRead"`  //累计从块设备读取的字节数
+	BlkioWrite  uint64 `json:"blkioWrite"` //累计写入块设备的字节数
+}
+
+//可以读取资源使用统计的 subsystem 实现这个接口
+type StatsSubsystem interface {
+
+	GetStats(path string, stats *Stats) error //把 cgroup 的统计数据填入 stats
+}
+
+//读取只包含一个数字的统计文件, "max" 和超大的 v1 默认值都当作没有限制, 返回 0
+func readUint(file string) (uint64, error) {
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+
+		return 0, err
+	}
+
+	str := strings.TrimSpace(string(content))
+	if str == "max" {
+
+		return 0, nil
+	}
+
+	value, err := strconv.ParseUint(str, 10, 64)
+	if err != nil {
+
+		return 0, err
+	}
+	//v1 中没有设置限制时 memory.limit_in_bytes 的值接近 int64 的最大值
+	if value >= 1<<62 {
+
+		return 0, nil
+	}
+
+	return value, nil
+}
+
+//读取 memory.stat, cpu.stat 这种每行为 "key value" 的统计文件
+func readKeyValues(file string) (map[string]uint64, error) {
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+
+		return nil, err
+	}
+
+	values := map[string]uint64{}
+	for _, line := range strings.Split(string(content), "\n") {
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if value, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+
+			values[fields[0]] = value
+		}
+	}
+
+	return values, nil
+}
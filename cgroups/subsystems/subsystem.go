@@ -1,11 +1,199 @@
 package subsystems
 
-//用于传递资源限制配置的结构体，包含内存限制，cup 时间权重， cpu 核心数
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+//用于传递资源限制配置的结构体，包含内存限制，cup 时间权重， cpu 核心数, 最大进程数, 块设备 IO 限制
 type ResourceConfig struct {
 
 	MemoryLimit string
+	MemorySwap 	string  //内存加 swap 的总限制, -1 表示不限制 swap
+	MemoryReservation string //内存软限制
+	OomKillDisable 	bool
 	CpuShare 	string
 	CpuSet 		string
+	Cpus 		string  //可以使用的 CPU 个数, 例如 1.5, 会换算成 CpuQuota 和 CpuPeriod
+	CpuQuota 	string  //每个 CpuPeriod 内可以使用的 CPU 时间, 单位微秒, -1 表示不限制
+	CpuPeriod 	string  //CFS 调度周期, 单位微秒
+	PidsLimit 	string
+
+	//块设备 IO 限制, 设备限速的格式为 <设备路径>:<速率>, 例如 /dev/sda:1048576
+	BlkioWeight 			string
+	BlkioDeviceReadBps 		[]string
+	BlkioDeviceWriteBps 	[]string
+	BlkioDeviceReadIops 	[]string
+	BlkioDeviceWriteIops 	[]string
+}
+
+//校验资源配置, 并把 512m, 2g 这种带单位的大小统一换算成字节数
+func (r *ResourceConfig) Validate() error {
+
+	var err error
+	if r.MemoryLimit, err = normalizeBytes("memory", r.MemoryLimit); err != nil {
+
+		return err
+	}
+	if r.MemorySwap, err = normalizeBytes("memory-swap", r.MemorySwap); err != nil {
+
+		return err
+	}
+	if r.MemoryReservation, err = normalizeBytes("memory-reservation", r.MemoryReservation); err != nil {
+
+		return err
+	}
+
+	limit, _ := strconv.ParseInt(r.MemoryLimit, 10, 64)
+	if r.MemorySwap != "" {
+
+		//和 docker 一样, memory-swap 是内存和 swap 的总和, 所以必须同时设置内存限制
+		if r.MemoryLimit == "" {
+
+			return fmt.Errorf("memory-swap requires memory limit to be set")
+		}
+		swap, _ := strconv.ParseInt(r.MemorySwap, 10, 64)
+		if swap != -1 && swap < limit {
+
+			return fmt.Errorf("memory-swap %s should be larger than memory limit %s", r.MemorySwap, r.MemoryLimit)
+		}
+	}
+
+	if r.MemoryReservation != "" && r.MemoryLimit != "" && limit != -1 {
+
+		reservation, _ := strconv.ParseInt(r.MemoryReservation, 10, 64)
+		if reservation > limit {
+
+			return fmt.Errorf("memory-reservation %s should be smaller than memory limit %s", r.MemoryReservation, r.MemoryLimit)
+		}
+	}
+
+	if r.OomKillDisable && r.MemoryLimit == "" {
+
+		return fmt.Errorf("oom-kill-disable requires memory limit to be set")
+	}
+
+	if err := r.validateCpuQuota(); err != nil {
+
+		return err
+	}
+
+	//pids.max 只接受正整数或者 max, 和 docker 一样 -1 也表示不限制
+	if r.PidsLimit == "-1" || r.PidsLimit == "max" {
+
+		r.PidsLimit = "max"
+	} else if r.PidsLimit != "" {
+
+		limit, err := strconv.ParseInt(r.PidsLimit, 10, 64)
+		if err != nil || limit <= 0 {
+
+			return fmt.Errorf("invalid pids-limit %s, should be a positive integer, -1 or max", r.PidsLimit)
+		}
+		r.PidsLimit = strconv.FormatInt(limit, 10)
+	}
+
+	//设备读写的字节速率同样支持单位, 例如 /dev/sda:10m
+	for _, rules := range [][]string{r.BlkioDeviceReadBps, r.BlkioDeviceWriteBps} {
+
+		for i, rule := range rules {
+
+			index := strings.LastIndex(rule, ":")
+			if index <= 0 {
+
+				return fmt.Errorf("invalid device throttle %s, format is <device>:<rate>", rule)
+			}
+			rate, err := normalizeBytes("device rate", rule[index+1:])
+			if err != nil {
+
+				return err
+			}
+			rules[i] = rule[:index+1] + rate
+		}
+	}
+
+	//v1 的 blkio.weight 和 v2 的 io.weight 都只接受 10 到 1000
+	if r.BlkioWeight != "" {
+
+		weight, err := strconv.ParseUint(r.BlkioWeight, 10, 64)
+		if err != nil || weight < 10 || weight > 1000 {
+
+			return fmt.Errorf("invalid blkio-weight %s, range is [10, 1000]", r.BlkioWeight)
+		}
+	}
+
+	//提前把所有的设备路径解析成 major:minor, 速率解析成无符号整数, 不要等到写入 cgroup 时才发现设备不存在
+	for _, rules := range [][]string{r.BlkioDeviceReadBps, r.BlkioDeviceWriteBps, r.BlkioDeviceReadIops, r.BlkioDeviceWriteIops} {
+
+		if _, err := parseDeviceThrottles(rules); err != nil {
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+//CFS 默认的调度周期 100ms
+const defaultCpuPeriod = 100000
+
+//检查 CPU 配额, 并把 --cpus 换算成 quota 和 period
+//例如 --cpus 1.5 对应每 100000us 中可以使用 150000us 的 CPU 时间
+func (r *ResourceConfig) validateCpuQuota() error {
+
+	hostCpus := runtime.NumCPU()
+
+	if r.Cpus != "" {
+
+		cpus, err := strconv.ParseFloat(r.Cpus, 64)
+		if err != nil || cpus <= 0 {
+
+			return fmt.Errorf("invalid cpus %s", r.Cpus)
+		}
+		if cpus > float64(hostCpus) {
+
+			return fmt.Errorf("cpus %s is larger than the number of cpus (%d) on the host", r.Cpus, hostCpus)
+		}
+
+		period := strconv.Itoa(defaultCpuPeriod)
+		quota := strconv.FormatInt(int64(cpus*defaultCpuPeriod), 10)
+		//已经换算过的配置再次校验时 quota 和 period 与 cpus 一致, 不算冲突
+		if (r.CpuQuota != "" && r.CpuQuota != quota) || (r.CpuPeriod != "" && r.CpuPeriod != period) {
+
+			return fmt.Errorf("cpus and cpu-quota/cpu-period can not both provided")
+		}
+
+		r.CpuPeriod = period
+		r.CpuQuota = quota
+	}
+
+	period := int64(defaultCpuPeriod)
+	if r.CpuPeriod != "" {
+
+		value, err := strconv.ParseInt(r.CpuPeriod, 10, 64)
+		//内核限制 period 的范围为 1ms 到 1s
+		if err != nil || value < 1000 || value > 1000000 {
+
+			return fmt.Errorf("invalid cpu-period %s, range is [1000, 1000000]", r.CpuPeriod)
+		}
+		period = value
+	}
+
+	if r.CpuQuota != "" && r.CpuQuota != "-1" {
+
+		quota, err := strconv.ParseInt(r.CpuQuota, 10, 64)
+		if err != nil || quota < 1000 {
+
+			return fmt.Errorf("invalid cpu-quota %s, should be -1 or larger than 1000", r.CpuQuota)
+		}
+		if quota > period*int64(hostCpus) {
+
+			return fmt.Errorf("cpu-quota %s exceeds the number of cpus (%d) on the host", r.CpuQuota, hostCpus)
+		}
+	}
+
+	return nil
 }
 
 //Subsystem 接口， 每个Subsystem 该接口可以实现下面的四个接口
@@ -24,5 +212,8 @@ var (
 		&CpusetSubSystem{},
 		&MemorySubSystem{},
 		&CpuSubSystem{},
+		&PidsSubSystem{},
+		&BlkioSubSystem{},
+		&FreezerSubSystem{},
 	}
 )
\ No newline at end of file
@@ -0,0 +1,69 @@
+package subsystems
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateBlkio(t *testing.T) {
+
+	tests := []struct {
+		name 	string
+		res 	ResourceConfig
+		err 	string
+	}{
+		{"weight too small", ResourceConfig{BlkioWeight: "9"}, "invalid blkio-weight"},
+		{"weight too large", ResourceConfig{BlkioWeight: "1001"}, "invalid blkio-weight"},
+		{"weight not a number", ResourceConfig{BlkioWeight: "high"}, "invalid blkio-weight"},
+		{"missing rate", ResourceConfig{BlkioDeviceReadBps: []string{"/dev/sda"}}, "invalid device throttle"},
+		{"missing device", ResourceConfig{BlkioDeviceWriteBps: []string{"/dev/ttdocker-none:1m"}}, "stat device"},
+		{"not a block device", ResourceConfig{BlkioDeviceReadIops: []string{"/dev/null:100"}}, "not a block device"},
+		{"negative iops", ResourceConfig{BlkioDeviceWriteIops: []string{"/dev/null:-1"}}, "invalid rate"},
+		{"fractional iops", ResourceConfig{BlkioDeviceReadIops: []string{"/dev/null:1.5"}}, "invalid rate"},
+	}
+
+	for _, test := range tests {
+
+		err := test.res.Validate()
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+
+			t.Errorf("%s: Validate() error = %v, want %s", test.name, err, test.err)
+		}
+	}
+
+	for _, weight := range []string{"10", "500", "1000"} {
+
+		res := ResourceConfig{BlkioWeight: weight}
+		if err := res.Validate(); err != nil {
+
+			t.Errorf("blkio-weight %s: Validate() error %v", weight, err)
+		}
+	}
+}
+
+func TestValidatePidsLimit(t *testing.T) {
+
+	for limit, want := range map[string]string{
+		"": "",
+		"100": "100",
+		"+64": "64",
+		"-1": "max",
+		"max": "max",
+	} {
+
+		res := ResourceConfig{PidsLimit: limit}
+		if err := res.Validate(); err != nil || res.PidsLimit != want {
+
+			t.Errorf("pids-limit %q: got %q, %v, want %q", limit, res.PidsLimit, err, want)
+		}
+	}
+
+	for _, limit := range []string{"0", "-2", "1.5", "unlimited", "MAX", "99999999999999999999"} {
+
+		res := ResourceConfig{PidsLimit: limit}
+		if err := res.Validate(); err == nil {
+
+			t.Errorf("pids-limit %q: expect error", limit)
+		}
+	}
+}
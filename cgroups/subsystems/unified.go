@@ -0,0 +1,181 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+var (
+	unifiedModeOnce sync.Once
+	// cgroup v2 统一层级(unified hierarchy) 的挂载点, 为空说明宿主机使用的是 v1
+	// 在 v2 下所有的控制器都挂载在同一个 hierarchy 上， 每个容器只对应一个 cgroup 目录
+	unifiedMountpoint string
+)
+
+//判断宿主机的 cgroup 是否运行在 v2 统一层级模式下, 检测结果只在第一次调用时计算
+func IsCgroup2UnifiedMode() bool {
+
+	unifiedModeOnce.Do(func() {
+
+		unifiedMountpoint = detectUnifiedMountpoint()
+	})
+
+	return unifiedMountpoint != ""
+}
+
+//通过 mountinfo 查找 cgroup2 的挂载点
+//hybrid 模式下 cgroup2 和 v1 的 hierarchy 同时存在, 依旧按照 v1 处理
+func detectUnifiedMountpoint() string {
+
+	mountpoint := ""
+	hasV1 := false
+
+	err := walkMountinfo(func(mnt string, fstype string, superOpts string) bool {
+
+		switch fstype {
+		case "cgroup2":
+			mountpoint = mnt
+		case "cgroup":
+			hasV1 = true
+		}
+		return false
+	})
+	if err != nil || hasV1 {
+
+		return ""
+	}
+
+	return mountpoint
+}
+
+//v2 下把进程加入 cgroup 写的是 cgroup.procs, v1 沿用 tasks
+func cgroupProcsFile() string {
+
+	if IsCgroup2UnifiedMode() {
+
+		return "cgroup.procs"
+	}
+
+	return "tasks"
+}
+
+//得到 v2 下 cgroup 的绝对路径, 所有 subsystem 共用 /sys/fs/cgroup/<cgroupPath> 这一个目录
+//autoCreate 时顺带在各级父 cgroup 中开启 subsystem 对应的控制器
+func getUnifiedCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string, error) {
+
+	absPath := path.Join(unifiedMountpoint, cgroupPath)
+
+	if _, err := os.Stat(absPath); err != nil {
+
+		if !(autoCreate && os.IsNotExist(err)) {
+
+			return "", fmt.Errorf("cgroup path %s error %w", absPath, err)
+		}
+
+		if err := os.MkdirAll(absPath, 0755); err != nil {
+
+			return "", fmt.Errorf("error create cgroup %s %v", absPath, err)
+		}
+	}
+
+	if controller := unifiedControllerName(subsystem); autoCreate && controller != "" {
+
+		if err := enableController(controller, cgroupPath); err != nil {
+
+			return "", err
+		}
+	}
+
+	return absPath, nil
+}
+
+//v1 中的 blkio 在 v2 中改名为 io, freezer 在 v2 中不是控制器, 返回空字符串, 其余控制器名字不变
+func unifiedControllerName(subsystem string) string {
+
+	switch subsystem {
+	case "blkio":
+		return "io"
+	case "freezer":
+		return ""
+	}
+
+	return subsystem
+}
+
+/*
+	v2 中子 cgroup 只能使用父 cgroup 在 cgroup.subtree_control 中开启了的控制器,
+	所以从根节点开始， 逐级把控制器写入 cgroupPath 各级祖先的 cgroup.subtree_control 中
+	相当于 echo "+memory" > /sys/fs/cgroup/cgroup.subtree_control
+*/
+func enableController(controller string, cgroupPath string) error {
+
+	parent := unifiedMountpoint
+	elems := strings.Split(strings.Trim(cgroupPath, "/"), "/")
+
+	for _, elem := range elems {
+
+		if err := writeSubtreeControl(parent, controller); err != nil {
+
+			return err
+		}
+		parent = path.Join(parent, elem)
+	}
+
+	return nil
+}
+
+func writeSubtreeControl(dir string, controller string) error {
+
+	enabled, err := ioutil.ReadFile(path.Join(dir, "cgroup.subtree_control"))
+	if err != nil {
+
+		return fmt.Errorf("read %s subtree_control error %v", dir, err)
+	}
+	//已经开启过了就不需要再写了
+	for _, name := range strings.Fields(string(enabled)) {
+
+		if name == controller {
+			return nil
+		}
+	}
+
+	available, err := ioutil.ReadFile(path.Join(dir, "cgroup.controllers"))
+	if err != nil {
+
+		return fmt.Errorf("read %s controllers error %v", dir, err)
+	}
+	found := false
+	for _, name := range strings.Fields(string(available)) {
+
+		if name == controller {
+			found = true
+		}
+	}
+	if !found {
+
+		return fmt.Errorf("controller %s is not available in %s", controller, dir)
+	}
+
+	if err := ioutil.WriteFile(path.Join(dir, "cgroup.subtree_control"), []byte("+"+controller), 0644); err != nil {
+
+		return fmt.Errorf("enable controller %s in %s error %v", controller, dir, err)
+	}
+
+	return nil
+}
+
+//v2 下所有 subsystem 共用一个目录, 删除一次即可
+func RemoveUnifiedCgroup(cgroupPath string) error {
+
+	absPath := path.Join(unifiedMountpoint, cgroupPath)
+	if err := removeCgroupDir(absPath); err != nil {
+
+		return fmt.Errorf("remove cgroup %s error %v", absPath, err)
+	}
+
+	return nil
+}
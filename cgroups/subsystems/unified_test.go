@@ -0,0 +1,239 @@
+package subsystems
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+//用伪造的 mountinfo 替换 /proc/self/mountinfo, 测试结束后恢复
+func fakeMountinfo(t *testing.T, lines ...string) {
+
+	SetMountinfoReader(func() (io.ReadCloser, error) {
+
+		return ioutil.NopCloser(strings.NewReader(strings.Join(lines, "\n") + "\n")), nil
+	})
+	t.Cleanup(func() {
+
+		SetMountinfoReader(func() (io.ReadCloser, error) {
+
+			return os.Open("/proc/self/mountinfo")
+		})
+	})
+}
+
+func cgroup2Line(mountpoint string) string {
+
+	return "30 23 0:26 / " + mountpoint + " rw,nosuid,nodev,noexec,relatime shared:4 - cgroup2 cgroup2 rw,nsdelegate"
+}
+
+func cgroup1Line(mountpoint string, subsystem string) string {
+
+	return "36 25 0:31 / " + mountpoint + " rw,nosuid,nodev,noexec,relatime shared:9 - cgroup cgroup rw," + subsystem
+}
+
+func writeFile(t *testing.T, file string, content string) {
+
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, file string) string {
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	return string(content)
+}
+
+//在临时目录中搭建一个 v2 的 cgroup 根节点, 根节点开启了 controllers 中的控制器
+func fakeUnifiedRoot(t *testing.T, controllers string) string {
+
+	root := t.TempDir()
+	writeFile(t, path.Join(root, "cgroup.controllers"), controllers)
+	writeFile(t, path.Join(root, "cgroup.subtree_control"), "")
+	fakeMountinfo(t,
+		"22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw",
+		cgroup2Line(root))
+
+	return root
+}
+
+func TestDetectCgroupMode(t *testing.T) {
+
+	tests := []struct {
+		name 		string
+		lines 		[]string
+		unified 	bool
+	}{
+		{"unified", []string{cgroup2Line("/sys/fs/cgroup")}, true},
+		{"hybrid", []string{cgroup1Line("/sys/fs/cgroup/memory", "memory"), cgroup2Line("/sys/fs/cgroup/unified")}, false},
+		{"v1", []string{cgroup1Line("/sys/fs/cgroup/memory", "memory"), cgroup1Line("/sys/fs/cgroup/cpu,cpuacct", "cpu,cpuacct")}, false},
+		{"none", []string{"22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw"}, false},
+	}
+
+	for _, test := range tests {
+
+		fakeMountinfo(t, test.lines...)
+		if unified := IsCgroup2UnifiedMode(); unified != test.unified {
+
+			t.Errorf("%s: IsCgroup2UnifiedMode() = %v, want %v", test.name, unified, test.unified)
+		}
+	}
+
+	fakeMountinfo(t, cgroup2Line("/sys/fs/cgroup"))
+	if file := cgroupProcsFile(); file != "cgroup.procs" {
+
+		t.Errorf("cgroupProcsFile() = %s in unified mode, want cgroup.procs", file)
+	}
+	fakeMountinfo(t, cgroup1Line("/sys/fs/cgroup/cpu,cpuacct", "cpu,cpuacct"))
+	if file := cgroupProcsFile(); file != "tasks" {
+
+		t.Errorf("cgroupProcsFile() = %s in v1 mode, want tasks", file)
+	}
+	if mnt := FindCgroupMountpoint("cpuacct"); mnt != "/sys/fs/cgroup/cpu,cpuacct" {
+
+		t.Errorf("FindCgroupMountpoint(cpuacct) = %s", mnt)
+	}
+}
+
+func TestUnifiedSet(t *testing.T) {
+
+	root := fakeUnifiedRoot(t, "cpuset cpu io memory pids")
+	res := &ResourceConfig{
+		MemoryLimit: "104857600",
+		CpuShare: "1024",
+		CpuSet: "0-1",
+		PidsLimit: "100",
+	}
+
+	for _, subsystem := range SubsystemsIns {
+
+		if err := subsystem.Set("test", res); err != nil {
+
+			t.Fatalf("%s Set error %v", subsystem.Name(), err)
+		}
+	}
+
+	dir := path.Join(root, "test")
+	for file, want := range map[string]string{
+		"memory.max": "104857600",
+		"cpu.weight": "39",
+		"cpuset.cpus": "0-1",
+		"pids.max": "100",
+	} {
+
+		if got := readFile(t, path.Join(dir, file)); got != want {
+
+			t.Errorf("%s = %q, want %q", file, got, want)
+		}
+	}
+
+	//伪造的 subtree_control 只是普通文件, 保留的是最后一次写入的控制器
+	if got := readFile(t, path.Join(root, "cgroup.subtree_control")); !strings.HasPrefix(got, "+") {
+
+		t.Errorf("cgroup.subtree_control = %q, want a controller enabled", got)
+	}
+
+	//不限制时 v2 写入 max
+	if err := (&MemorySubSystem{}).Set("test", &ResourceConfig{MemoryLimit: "-1"}); err != nil {
+
+		t.Fatal(err)
+	}
+	if got := readFile(t, path.Join(dir, "memory.max")); got != "max" {
+
+		t.Errorf("memory.max = %q, want max", got)
+	}
+}
+
+func TestUnifiedEnableController(t *testing.T) {
+
+	root := fakeUnifiedRoot(t, "cpu memory")
+	writeFile(t, path.Join(root, "ttdocker.slice", "cgroup.controllers"), "memory")
+	writeFile(t, path.Join(root, "ttdocker.slice", "cgroup.subtree_control"), "")
+
+	if err := (&MemorySubSystem{}).Set("ttdocker.slice/c1", &ResourceConfig{MemoryLimit: "1048576"}); err != nil {
+
+		t.Fatal(err)
+	}
+
+	//从根节点开始, 每一级祖先都要开启控制器
+	for _, dir := range []string{root, path.Join(root, "ttdocker.slice")} {
+
+		if got := readFile(t, path.Join(dir, "cgroup.subtree_control")); got != "+memory" {
+
+			t.Errorf("%s/cgroup.subtree_control = %q, want +memory", dir, got)
+		}
+	}
+	if got := readFile(t, path.Join(root, "ttdocker.slice", "c1", "memory.max")); got != "1048576" {
+
+		t.Errorf("memory.max = %q, want 1048576", got)
+	}
+
+	//已经开启的控制器不再写入
+	writeFile(t, path.Join(root, "cgroup.subtree_control"), "cpu memory")
+	if err := writeSubtreeControl(root, "memory"); err != nil {
+
+		t.Fatal(err)
+	}
+	if got := readFile(t, path.Join(root, "cgroup.subtree_control")); got != "cpu memory" {
+
+		t.Errorf("cgroup.subtree_control = %q, want it unchanged", got)
+	}
+
+	//父 cgroup 中没有的控制器不能开启
+	if err := (&PidsSubSystem{}).Set("ttdocker.slice/c1", &ResourceConfig{PidsLimit: "10"}); err == nil {
+
+		t.Errorf("expect error when pids controller is not available")
+	}
+}
+
+func TestUnifiedApplyAndRemove(t *testing.T) {
+
+	root := fakeUnifiedRoot(t, "memory")
+	if err := (&MemorySubSystem{}).Set("test", &ResourceConfig{MemoryLimit: "1048576"}); err != nil {
+
+		t.Fatal(err)
+	}
+
+	if err := (&MemorySubSystem{}).Apply("test", 1234); err != nil {
+
+		t.Fatal(err)
+	}
+	if got := readFile(t, path.Join(root, "test", "cgroup.procs")); got != "1234" {
+
+		t.Errorf("cgroup.procs = %q, want 1234", got)
+	}
+
+	//真正的 cgroup 目录中的文件由内核管理, 这里先删掉伪造的文件才能 rmdir
+	files, _ := ioutil.ReadDir(path.Join(root, "test"))
+	for _, file := range files {
+
+		os.Remove(path.Join(root, "test", file.Name()))
+	}
+	if err := RemoveUnifiedCgroup("test"); err != nil {
+
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(root, "test")); !os.IsNotExist(err) {
+
+		t.Errorf("cgroup dir still exists after remove: %v", err)
+	}
+	//重复删除不报错
+	if err := RemoveUnifiedCgroup("test"); err != nil {
+
+		t.Errorf("remove removed cgroup error %v", err)
+	}
+}
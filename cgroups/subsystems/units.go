@@ -0,0 +1,81 @@
+package subsystems
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//单位对应的字节数, 与 docker 一样按 1024 进制计算
+var byteUnits = map[string]int64{
+	"":  1,
+	"b": 1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+}
+
+//把 512m, 2g, 1.5G, 100kb 这种带单位的大小解析成字节数
+//-1 表示不做限制, 原样返回
+func ParseBytes(size string) (int64, error) {
+
+	str := strings.ToLower(strings.TrimSpace(size))
+	if str == "-1" {
+
+		return -1, nil
+	}
+
+	//支持 kb mb gb 这种写法, 去掉结尾的 b 之后再取单位
+	if len(str) > 1 && strings.HasSuffix(str, "b") {
+
+		if _, ok := byteUnits[str[len(str)-2:len(str)-1]]; ok {
+
+			str = str[:len(str)-1]
+		}
+	}
+
+	unit := ""
+	if len(str) > 0 {
+
+		if _, ok := byteUnits[str[len(str)-1:]]; ok {
+
+			unit = str[len(str)-1:]
+			str = str[:len(str)-1]
+		}
+	}
+
+	//ParseFloat 能解析 NaN 和 Inf, 需要单独排除
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil || value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+
+		return 0, fmt.Errorf("invalid size %s", size)
+	}
+
+	//换算成字节之后不能超出 int64 的范围, float64(math.MaxInt64) 等于 2^63, 所以用 >= 比较
+	bytes := value * float64(byteUnits[unit])
+	if bytes >= float64(math.MaxInt64) {
+
+		return 0, fmt.Errorf("size %s is too large", size)
+	}
+
+	return int64(bytes), nil
+}
+
+//把带单位的大小转换成以字节为单位的字符串, 空字符串表示没有设置
+func normalizeBytes(name string, size string) (string, error) {
+
+	if size == "" {
+
+		return "", nil
+	}
+
+	value, err := ParseBytes(size)
+	if err != nil {
+
+		return "", fmt.Errorf("%s: %v", name, err)
+	}
+
+	return strconv.FormatInt(value, 10), nil
+}
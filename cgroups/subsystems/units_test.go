@@ -0,0 +1,68 @@
+package subsystems
+
+import (
+	"testing"
+)
+
+func TestParseBytes(t *testing.T) {
+
+	tests := []struct {
+		size 	string
+		want 	int64
+	}{
+		{"0", 0},
+		{"1024", 1024},
+		{"-1", -1},
+		{"100b", 100},
+		{"1k", 1 << 10},
+		{"1kb", 1 << 10},
+		{"512m", 512 << 20},
+		{"512MB", 512 << 20},
+		{"2g", 2 << 30},
+		{"1.5G", 3 << 29},
+		{" 1t ", 1 << 40},
+	}
+
+	for _, test := range tests {
+
+		got, err := ParseBytes(test.size)
+		if err != nil || got != test.want {
+
+			t.Errorf("ParseBytes(%q) = %d, %v, want %d", test.size, got, err, test.want)
+		}
+	}
+}
+
+func TestParseBytesInvalid(t *testing.T) {
+
+	for _, size := range []string{
+		"",
+		"m",
+		"abc",
+		"-2",
+		"-1k",
+		"1x",
+		"NaN",
+		"nanm",
+		"inf",
+		"+Inf",
+		"infg",
+		"1e400",
+		//超出 int64 的范围
+		"9223372036854775808",
+		"8388608t",
+		"1e10g",
+	} {
+
+		if got, err := ParseBytes(size); err == nil {
+
+			t.Errorf("ParseBytes(%q) = %d, want error", size, got)
+		}
+	}
+
+	//int64 范围内最大的以 t 为单位的值
+	if got, err := ParseBytes("8388607t"); err != nil || got != 8388607 << 40 {
+
+		t.Errorf("ParseBytes(8388607t) = %d, %v", got, err)
+	}
+}
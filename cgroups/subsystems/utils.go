@@ -2,19 +2,41 @@ package subsystems
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
-//通过  /proc/self/rnountinfo 找出挂载了某个 subsystem 的 hierarchy cgroup 根节点所在的目录
-func FindCgroupMountpoint(subsystem string) string {
+//读取 mountinfo 的函数, 默认读取 /proc/self/mountinfo
+//替换成伪造的 mountinfo 后, 所有 hierarchy 的挂载点都从伪造的内容中获取, 这样就可以在临时目录搭建的假 cgroup 文件系统上运行
+var mountinfoReader = func() (io.ReadCloser, error) {
+
+	return os.Open("/proc/self/mountinfo")
+}
+
+//替换读取 mountinfo 的函数, 同时清空之前检测到的 cgroup 模式
+func SetMountinfoReader(reader func() (io.ReadCloser, error)) {
+
+	mountinfoReader = reader
+	unifiedModeOnce = sync.Once{}
+	unifiedMountpoint = ""
+}
+
+//遍历 mountinfo 中的每一个挂载点, handler 返回 true 时停止遍历
+//一行 mountinfo 的格式如下, 分隔符 "-" 后面依次是文件系统类型, 挂载源和超级块选项
+//36 35 98:0 / /sys/fs/cgroup/memory rw,nosuid - cgroup cgroup rw,memory
+func walkMountinfo(handler func(mountpoint string, fstype string, superOpts string) bool) error {
 
-	f, err := os.Open("/proc/self/mountinfo")
+	f, err := mountinfoReader()
 	if err != nil {
 
-		return ""
+		return err
 	}
 	defer f.Close()
 
@@ -25,21 +47,77 @@ func FindCgroupMountpoint(subsystem string) string {
 
 		txt := scanner.Text()
 		fields := strings.Split(txt, " ") //去掉 txt 中间的空格
+		if len(fields) < 5 {
+			continue
+		}
 
-		for _, opt := range strings.Split(fields[len(fields) - 1], ","){
+		fstype := ""
+		for i, field := range fields {
 
-			if opt == subsystem {
+			if field == "-" && i+1 < len(fields) {
 
-				return fields[4]
+				fstype = fields[i+1]
+				break
 			}
 		}
+
+		if handler(fields[4], fstype, fields[len(fields) - 1]) {
+
+			return nil
+		}
 	}
-	if err := scanner.Err(); err != nil {
+
+	return scanner.Err()
+}
+
+//通过  /proc/self/rnountinfo 找出挂载了某个 subsystem 的 hierarchy cgroup 根节点所在的目录
+func FindCgroupMountpoint(subsystem string) string {
+
+	mountpoint := ""
+	err := walkMountinfo(func(mnt string, fstype string, superOpts string) bool {
+
+		if fstype != "cgroup" {
+			return false
+		}
+
+		for _, opt := range strings.Split(superOpts, ","){
+
+			if opt == subsystem {
+
+				mountpoint = mnt
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
 
 		return ""
 	}
 
-	return ""
+	return mountpoint
+}
+
+//删除 cgroup 目录, cgroup 目录中的文件不能删除, 只能 rmdir 整个目录
+//进程刚刚退出时内核可能还没有把它从 cgroup 中移除, rmdir 会返回 EBUSY, 这里稍等之后重试
+func removeCgroupDir(dir string) error {
+
+	var err error
+	for i := 0; i < 10; i++ {
+
+		err = os.Remove(dir)
+		if err == nil || os.IsNotExist(err) {
+
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) {
+
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return err
 }
 
 //得到cgroup 在文件系统中的绝对路径
@@ -54,24 +132,67 @@ func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string
 		举例： memory  首先要进入当前 hierarchy, 就是进入当前manager 对应的的目录，这个目录在run 期间已经建立，名为 mydaocker-cgroup
 			然后就是在 下面创建 memory.limit_in_bytes 这个文件，然后把限制资源的 大小写入文件中就星
 	*/
+	//cgroup v2 下所有的 subsystem 都在同一个 hierarchy 中
+	if IsCgroup2UnifiedMode() {
+
+		return getUnifiedCgroupPath(subsystem, cgroupPath, autoCreate)
+	}
+
 	//stat返回一个描述name指定的文件对象的FileInfo。,如果不存在，根据autocreate 创建一个
 	cgroupRoot := FindCgroupMountpoint(subsystem)
+	if cgroupRoot == "" {
+
+		//没有挂载的 subsystem 中也就没有容器的 cgroup, 包装成 ErrNotExist, 释放 cgroup 时可以直接跳过
+		return "", fmt.Errorf("subsystem %s is not mounted: %w", subsystem, os.ErrNotExist)
+	}
 
 	if _, err := os.Stat(path.Join(cgroupRoot, cgroupPath)); err == nil || (autoCreate && os.IsNotExist(err)) {
 
 		if os.IsNotExist(err) {
 
-			if err := os.Mkdir(path.Join(cgroupRoot, cgroupPath), 0755); err == nil {
-
-			} else {
+			//cgroupPath 可能带有父 cgroup, 例如 ttdocker.slice/<id>, 需要逐级创建
+			if err := mkdirCgroup(subsystem, cgroupRoot, cgroupPath); err != nil {
 
-				return "", fmt.Errorf("error create cgroup #{err}")
+				return "", fmt.Errorf("error create cgroup %v", err)
 			}
 		}
 
 		return path.Join(cgroupRoot, cgroupPath), nil
 	}else{
 
-		return "", fmt.Errorf("cgroup path error #{err}")
+		return "", fmt.Errorf("cgroup path error %w", err)
 	}
-}
\ No newline at end of file
+}
+
+//从 hierarchy 的根目录开始逐级创建 cgroup 目录
+//v1 中新建的 cpuset cgroup 的 cpuset.cpus 和 cpuset.mems 是空的, 不能加入进程, 每新建一级都要从父 cgroup 继承
+func mkdirCgroup(subsystem string, cgroupRoot string, cgroupPath string) error {
+
+	dir := cgroupRoot
+	for _, name := range strings.Split(path.Clean(cgroupPath), "/") {
+
+		if name == "" {
+			continue
+		}
+
+		parent := dir
+		dir = path.Join(dir, name)
+		if err := os.Mkdir(dir, 0755); err != nil {
+
+			if os.IsExist(err) {
+				continue
+			}
+			return err
+		}
+
+		if subsystem == "cpuset" {
+
+			if err := inheritCpuset(parent, dir); err != nil {
+
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -3,21 +3,58 @@ package main
 import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
-	"os/exec"
+	"ttdocker/cgroups"
 	"ttdocker/container"
+	"ttdocker/image"
 )
 
-func commitContainer(containerName, imageName string){
+//把容器的读写层保存成一个新的层, 叠加在容器所用镜像的各层之上生成新的镜像
+//新镜像和原来的镜像共用下面的各层, 不再复制整个根目录
+//新镜像的运行参数继承原来的镜像, 再执行 --change 指定的指令
+func commitContainer(containerName, imageName string, changes []string) error {
 
-	mntURL := fmt.Sprintf(container.MntUrl, containerName)
-	mntURL += "/"
-	imageTar := container.RootUrl + "/" +  imageName + ".tar"
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
 
-	// tar -czf /root/imnaeName.tar -C mntURL .
-	//将 mntURL 压缩到 .(当前目录)                                                      执行命令并返回标准输出和错误处理合并的切片
-	if _, err := exec.Command("tar", "-czf", imageTar, "-C", mntURL, ".").CombinedOutput(); err != nil {
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+
+	//和 docker commit 一样, 保存读写层期间冻结运行中的容器, 避免保存进写了一半的文件
+	if container.HasStatus(containerInfo, container.RUNNING) {
 
-		log.Errorf("Tar folder %s error %v", mntURL, err)
+		cgroupManager := cgroups.NewCgroupManager(containerInfo.CgroupPath)
+		if err := cgroupManager.Freeze(); err != nil {
+
+			return fmt.Errorf("pause container %s error %v", containerName, err)
+		}
+		defer cgroupManager.Thaw()
 	}
-}
 
+	parent, err := getContainerImage(containerInfo.ImageID, containerInfo.Image)
+	if err != nil {
+
+		return err
+	}
+
+	config, err := image.ApplyChanges(parent.Config, changes)
+	if err != nil {
+
+		return err
+	}
+
+	//读写层中只有容器相对于镜像的改动, 数据卷挂载在容器根目录上, 不在读写层中
+	writeLayer := fmt.Sprintf(container.WriteLayerUrl, containerName)
+	img, err := image.Create(parent, writeLayer, containerInfo.StorageDriver, containerInfo.Id, config)
+	if err != nil {
+
+		return err
+	}
+	if err := image.SetReference(imageName, img.ID); err != nil {
+
+		return err
+	}
+
+	log.Infof("commit container %s to image %s %s", containerName, imageName, img.ID)
+	fmt.Println(img.ID)
+	return nil
+}
@@ -0,0 +1,37 @@
+package container
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+//原来的 aufs 挂载方式, 只有打了 aufs 补丁的内核才支持
+type AufsDriver struct {
+}
+
+func (d *AufsDriver) Name() string {
+
+	return "aufs"
+}
+
+//dirs 中第一个目录是可写的读写层, 后面的都是只读层
+func (d *AufsDriver) Mount(lowerDirs []string, containerName string) error {
+
+	writeLayer := fmt.Sprintf(WriteLayerUrl, containerName)
+	mntUrl := fmt.Sprintf(MntUrl, containerName)
+	dirs := "dirs=" + strings.Join(append([]string{writeLayer}, lowerDirs...), ":")
+
+	if err := syscall.Mount("none", mntUrl, "aufs", 0, dirs); err != nil {
+
+		return fmt.Errorf("mount aufs %s error %v", mntUrl, err)
+	}
+
+	return nil
+}
+
+//aufs 只用到了读写层, 没有其他目录需要删除
+func (d *AufsDriver) Remove(containerName string) error {
+
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"syscall"
+	"ttdocker/cgroups/subsystems"
 )
 //一个容器的基本信息
 type ContainerInfo struct {
@@ -13,26 +14,47 @@ type ContainerInfo struct {
 	Id 			string `json:"id"`		// 容器ID
 	Name 		string `json:"name"`  // 容器名
 	Command 	string `json:"command"` //容器内init 进程的运行命令
-	CreatedTime string `json:"createTime"` //创建时间
+	CreatedTime string `json:"createTime"` //创建时间, RFC3339 格式
 	Status 		string `json:"status"`    //容器的状态
 	Volume 		string `json:"volume"`   //容器的数据卷
 	PortMapping []string `json:"portmapping"`  //端口映射
+	CgroupPath 	string `json:"cgroupPath"`  //容器的 cgroup 相对于 hierarchy 根节点的路径
+	OOMKilled 	bool `json:"oomKilled"`  //容器是否因为内存超出限制被 OOM killer 杀掉
+	Resource 	*subsystems.ResourceConfig `json:"resource"` //容器的资源限制
+	ExitCode 	int `json:"exitCode"`  //容器 init 进程的退出码, 被信号杀掉时为 128 + 信号值
+	FinishedTime string `json:"finishedTime"`  //容器退出的时间
+	RestartPolicy string `json:"restartPolicy"`  //容器的重启策略, 例如 on-failure:3
+	RestartCount int `json:"restartCount"`  //容器被自动重启的次数
+	ManuallyStopped bool `json:"manuallyStopped"`  //容器被 stop 停止, 不再自动重启
+	Image 		string `json:"image"`  //容器使用的镜像
+	ImageID 	string `json:"imageId"`  //容器使用的镜像的 ID, 镜像名指向其他镜像之后容器仍然使用原来的镜像
+	Args 		[]string `json:"args"`  //容器内 init 进程的运行命令, 每个参数单独保存
+	Env 		[]string `json:"env"`  //镜像中的环境变量和 -e 指定的环境变量
+	Network 	string `json:"network"`  //容器连接的网络
+	AutoRemove 	bool `json:"autoRemove"`  //容器退出后自动删除
+	Init 		bool `json:"init"`  //容器内运行 init 进程转发信号和回收僵尸进程
+	User 		string `json:"user"`  //运行用户命令的用户, exec 默认也使用这个用户
+	WorkingDir 	string `json:"workingDir"`  //用户命令的工作目录, exec 默认也使用这个目录
+	Hostname 	string `json:"hostname"`  //容器的主机名
+	Labels 		map[string]string `json:"labels"`  //--label 指定的标签, ps 可以按标签过滤
+	ExposedPorts []string `json:"exposedPorts"`  //镜像声明的端口, 例如 80/tcp, 需要 -p 映射之后才能从外部访问
+	StorageDriver string `json:"storageDriver"`  //创建容器时使用的存储驱动, 删除容器时使用同一个驱动清理
 }
 
-// 状态  全局变量
+// 全局变量, 容器的状态定义在 state.go 中
 var (
-	RUNNING 			string = "running"
-	STOP 				string = "stopped"
-	Exit 				string = "exited"
 	DefaultInfoLocation string = "/var/run/ttdocker/%s/"
 	ConfigName  		string = "config.json"
 	ContainerLogFile 	string = "container.log"
+	ShimLogFile 		string = "shim.log"
+	StartFifoName 		string = "start.fifo"
 	RootUrl 			string = "/root"
 	MntUrl 				string = "/root/mnt/%s"
 	WriteLayerUrl 		string = "/root/writeLayer/%s"
+	WorkDirUrl 			string = "/root/work/%s"
 )
 
-func NewParentProcess(tty bool, volume string, containerName string, imageName string, envSlice []string) (*exec.Cmd, *os.File) {
+func NewParentProcess(tty bool, volume string, containerName string, lowerDirs []string, envSlice []string, tinyInit bool, storageDriver string) (*exec.Cmd, *os.File) {
 
 	readPipe, writePipe, err := NewPipe()
 	if err != nil {
@@ -41,6 +63,10 @@ func NewParentProcess(tty bool, volume string, containerName string, imageName s
 		return nil, nil
 	}
 	cmd := exec.Command("/proc/self/exe", "init")
+	if tinyInit {
+
+		cmd.Args = append(cmd.Args, "--tiny-init")
+	}
 	//fork 出一个新进程
 	//在cmd.Run 的时候，会调用系统调用的 clone()。
 	cmd.SysProcAttr = &syscall.SysProcAttr{
@@ -50,7 +76,16 @@ func NewParentProcess(tty bool, volume string, containerName string, imageName s
 			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUSER,
 
 			UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: syscall.Getuid(), Size: 1,},},
-			GidMappings: []syscall.SysProcIDMap{{ContainerID: 0,HostID: syscall.Getuid(),Size: 1,},},
+			GidMappings: []syscall.SysProcIDMap{{ContainerID: 0,HostID: syscall.Getgid(),Size: 1,},},
+	}
+
+	//以 root 运行时把容器内的 0-65535 映射到宿主机相同的 ID, 容器内才有其他用户可以通过 -u 切换
+	//普通用户只能映射自己, 容器内只有 root
+	if syscall.Getuid() == 0 {
+
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: 0, Size: 65536}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: 0, Size: 65536}}
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = true
 	}
 
 	if tty {
@@ -67,8 +102,9 @@ func NewParentProcess(tty bool, volume string, containerName string, imageName s
 			return nil, nil
 		}
 
+		//容器重启之后接着原来的日志继续写
 		stdLogFilePath := dirURL + ContainerLogFile
-		stdLogFile, err := os.Create(stdLogFilePath)
+		stdLogFile, err := os.OpenFile(stdLogFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
 
 			log.Errorf("newParentProcess create file %s error %v", stdLogFilePath, err)
@@ -83,7 +119,11 @@ func NewParentProcess(tty bool, volume string, containerName string, imageName s
 	cmd.Env = append(os.Environ(), envSlice...)
 
 	//切换到　/root/busybox　目录
-	NewWorkSpace(volume,imageName, containerName)
+	if err := NewWorkSpace(volume, lowerDirs, containerName, storageDriver); err != nil {
+
+		log.Errorf("new workspace error %v", err)
+		return nil, nil
+	}
 
 	cmd.Dir = fmt.Sprintf(MntUrl, containerName)
 
@@ -3,7 +3,6 @@ package container
 import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -14,32 +13,59 @@ import (
 //每个包都有init() 函数, 程序如果包括这个包，就先执行这个包里面的init() 函数
 //这里的 init 函数是在容器内部执行的，也就是说 ， 代码执行到这里后 ， 容器所在的进程其实就已经创建出来了，
 //这是本容器执行的第一个进程。
-func RunContainerInitProcess() error {
+//tinyInit 为 true 时 init 进程不 exec 成用户命令, 而是作为 1 号进程转发信号和回收子进程
+func RunContainerInitProcess(tinyInit bool) error {
 
 	//init 进去读取了 父进程传递过来的参数后，然后在子进程内进行了执行， 完成了将用户指定命令传递给子进程的操作
-	cmdArray := readUserCommand()
-	if cmdArray == nil || len(cmdArray) == 0 {
+	spec, err := readInitSpec()
+	if err != nil {
+
+		return err
+	}
+	if len(spec.Args) == 0 {
 		return fmt.Errorf("Run Container get user command error , cmdArray is nil")
 	}
 
-	setUpMnout()
+	setUpMnout(spec.Mounts)
 
-		//  这里的 MountFlag 的意思如下。
-		//。 MS NOEXEC 在本文件系统中不允许运行其他程序。
-		//。 MS二NOSUID 在本系统中运行程序的时候， 不允许 set-user-ID 或 set-group-ID 。
-		//。 MS NODEV 这个参数是自 从 Linux 2.4 以来，所有 mount 的系统都会默认设定的参数。
+	//UTS namespace 中的主机名默认和宿主机相同
+	if spec.Hostname != "" {
 
-	//argv := []string{command}
+		if err := syscall.Sethostname([]byte(spec.Hostname)); err != nil {
 
-	//exec 实现了完成初始化动作并将用户进程运行起来的操作
-	//exec 执行command 对应的程序
+			return fmt.Errorf("set hostname %s error %v", spec.Hostname, err)
+		}
+	}
+
+	//和 docker 一样, 工作目录不存在时创建
+	if spec.Cwd != "" {
+
+		if err := os.MkdirAll(spec.Cwd, 0755); err != nil {
+
+			return fmt.Errorf("mkdir %s error %v", spec.Cwd, err)
+		}
+		if err := os.Chdir(spec.Cwd); err != nil {
+
+			return fmt.Errorf("chdir %s error %v", spec.Cwd, err)
+		}
+	}
 
+	//用户命令的环境变量替换掉 init 进程的环境变量, 这样下面 LookPath 使用的也是容器的 PATH
+	os.Clearenv()
+	for _, env := range spec.Env {
 
-	// 3-2
+		if kv := strings.SplitN(env, "=", 2); len(kv) == 2 {
+
+			os.Setenv(kv[0], kv[1])
+		}
+	}
+
+	//exec 实现了完成初始化动作并将用户进程运行起来的操作
+	//exec 执行command 对应的程序
 
 	//改动，调用 exec.LookPath，可以在系统的 PATH 里面寻找命令的绝对路径
 	// 举例： 如果输入的命令为 ls, LookPath  处理后的 为 /bin/ls 然后运行起来
-	path, err := exec.LookPath(cmdArray[0])
+	path, err := exec.LookPath(spec.Args[0])
 	if err != nil {
 
 		log.Errorf("Exec loop path error (%v)", err)
@@ -47,9 +73,20 @@ func RunContainerInitProcess() error {
 	}
 	log.Infof("find path %s", path)
 
-	if err := syscall.Exec(path, cmdArray[0:], os.Environ()); err != nil {
+	//最后切换用户, 切换之后就没有权限做上面的操作了
+	if err := setUser(spec.User); err != nil {
+
+		return err
+	}
+
+	if tinyInit {
+
+		return runTinyInit(path, spec.Args, os.Environ())
+	}
+
+	if err := syscall.Exec(path, spec.Args, os.Environ()); err != nil {
 
-		log.Errorf(err.Error())
+		log.Errorf("exec %s error %v", path, err)
 	}
 
 
@@ -57,33 +94,73 @@ func RunContainerInitProcess() error {
 
 }
 
-func readUserCommand() []string {
+//从 init 管道中读取父进程发送的 InitSpec
+func readInitSpec() (*InitSpec, error) {
 
 	//uintptr(3）就是指 index 为 3 的文件描述符，也就是传递进来的管道的一端
 	pipe := os.NewFile(uintptr(3), "pipe")
+	defer pipe.Close()
 
-	msg, err := ioutil.ReadAll(pipe)
+	spec, err := ReadInitSpec(pipe)
 	if err != nil {
 
 		log.Errorf("init read pipe error %v", err)
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+//按照 user[:group] 切换用户, 名字从容器内的 /etc/passwd 和 /etc/group 中查找
+func setUser(user string) error {
+
+	if user == "" {
+
 		return nil
 	}
 
-	msgStr := string(msg)
-	return strings.Split(msgStr, " ")
+	uid, gid, err := LookupUser("/", user)
+	if err != nil {
+
+		return err
+	}
+
+	//清空从父进程继承的附加组, user namespace 禁止了 setgroups 时会失败, 这时没有附加组可以清空
+	if err := syscall.Setgroups([]int{}); err != nil {
+
+		log.Debugf("setgroups error %v", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+
+		return fmt.Errorf("setgid %d error %v", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+
+		return fmt.Errorf("setuid %d error %v", uid, err)
+	}
+
+	return nil
 }
 
-func setUpMnout(){
+//切换到新的根目录, 然后挂载父进程指定的文件系统
+func setUpMnout(mounts []Mount){
 
 	//　获取当前路径　/root/busybox/
 	pivotRoot()
 
 	syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, "")
 
-	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
-	//挂载proc 系统
-	syscall.Mount("proc","/proc","proc",uintptr(defaultMountFlags),"")
-	syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID | syscall.MS_STRICTATIME, "mode=755")
+	for _, m := range mounts {
+
+		if err := os.MkdirAll(m.Destination, 0755); err != nil {
+
+			log.Errorf("mkdir %s error %v", m.Destination, err)
+		}
+		if err := syscall.Mount(m.Source, m.Destination, m.Type, m.Flags, m.Data); err != nil {
+
+			log.Errorf("mount %s to %s error %v", m.Type, m.Destination, err)
+		}
+	}
 }
 /*
 	使用pivot_root 实现rootfs切换和隔离
@@ -0,0 +1,77 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"syscall"
+)
+
+/*
+	父进程通过 init 管道发送给容器 init 进程的内容, 以 json 编码
+	之前用空格拼接命令, init 再按空格拆开, sh -c "echo a b" 这种带空格的参数会被拆坏
+*/
+type InitSpec struct {
+	Args 		[]string `json:"args"`  //用户命令, 每个参数单独保存
+	Env 		[]string `json:"env"`  //用户命令的环境变量
+	Cwd 		string `json:"cwd"`  //用户命令的工作目录, 为空时是容器的根目录
+	User 		string `json:"user"`  //运行用户命令的用户, 格式为 user[:group]
+	Hostname 	string `json:"hostname"`  //容器的主机名
+	Mounts 		[]Mount `json:"mounts"`  //切换根目录之后在容器内挂载的文件系统
+}
+
+//容器内的一个挂载点, 字段和 mount 系统调用的参数一一对应
+type Mount struct {
+	Source 		string `json:"source"`
+	Destination string `json:"destination"`
+	Type 		string `json:"type"`
+	Flags 		uintptr `json:"flags"`
+	Data 		string `json:"data"`
+}
+
+//每个容器都需要的挂载点, proc 和 /dev
+//MS_NOEXEC 在本文件系统中不允许运行其他程序, MS_NOSUID 运行程序时不允许 set-user-ID 或 set-group-ID
+//MS_NODEV 这个参数是自从 Linux 2.4 以来, 所有 mount 的系统都会默认设定的参数
+func DefaultMounts() []Mount {
+
+	return []Mount{
+		{
+			Source: "proc",
+			Destination: "/proc",
+			Type: "proc",
+			Flags: syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV,
+		},
+		{
+			Source: "tmpfs",
+			Destination: "/dev",
+			Type: "tmpfs",
+			Flags: syscall.MS_NOSUID | syscall.MS_STRICTATIME,
+			Data: "mode=755",
+		},
+	}
+}
+
+//把 InitSpec 编码成 json 写入 init 管道
+func WriteInitSpec(w io.Writer, spec *InitSpec) error {
+
+	return json.NewEncoder(w).Encode(spec)
+}
+
+//从 init 管道中读取全部内容并解码, 父进程关闭管道之后才会返回
+func ReadInitSpec(r io.Reader) (*InitSpec, error) {
+
+	msg, err := ioutil.ReadAll(r)
+	if err != nil {
+
+		return nil, err
+	}
+
+	var spec InitSpec
+	if err := json.Unmarshal(msg, &spec); err != nil {
+
+		return nil, fmt.Errorf("decode init spec error %v", err)
+	}
+
+	return &spec, nil
+}
@@ -0,0 +1,63 @@
+package container
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+//带空格, 引号, 转义字符和换行的参数经过 init 管道之后保持原样, 不会被拆开或者合并
+func TestInitSpecKeepsArguments(t *testing.T) {
+
+	tests := [][]string{
+		{"sh", "-c", "echo a b"},
+		{"sh", "-c", `echo "a  b" 'c d'`},
+		{"printf", `%s\n`, `back\slash`, `"`, `'`},
+		{"echo", "", "  ", "\t"},
+		{"echo", "line1\nline2"},
+		{"echo", "$HOME", "`id`", "a;b", "a|b", "*"},
+		{"echo", "中文 参数"},
+	}
+
+	for _, args := range tests {
+
+		r, w, err := os.Pipe()
+		if err != nil {
+
+			t.Fatal(err)
+		}
+		spec := &InitSpec{Args: args, Env: []string{"A=x y", `B="q"`}, Cwd: "/work dir", User: "app:app", Hostname: "c1", Mounts: DefaultMounts()}
+		go func() {
+
+			WriteInitSpec(w, spec)
+			w.Close()
+		}()
+
+		got, err := ReadInitSpec(r)
+		r.Close()
+		if err != nil {
+
+			t.Fatalf("ReadInitSpec(%q) error %v", args, err)
+		}
+		if !reflect.DeepEqual(got, spec) {
+
+			t.Errorf("init spec = %+v, want %+v", got, spec)
+		}
+	}
+}
+
+func TestReadInitSpecInvalid(t *testing.T) {
+
+	r, w, err := os.Pipe()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	w.WriteString("sh -c echo")
+	w.Close()
+
+	if _, err := ReadInitSpec(r); err == nil {
+
+		t.Errorf("expect error when init spec is not json")
+	}
+}
@@ -0,0 +1,79 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+/*
+	overlay2 驱动使用主线内核自带的 overlay 文件系统
+	lowerdir 是只读层, 多个只读层用冒号分隔, 上层在前
+	upperdir 是容器的读写层, workdir 是 overlay 内部使用的空目录, 必须和 upperdir 在同一个文件系统上
+*/
+type Overlay2Driver struct {
+}
+
+func (d *Overlay2Driver) Name() string {
+
+	return "overlay2"
+}
+
+func (d *Overlay2Driver) Mount(lowerDirs []string, containerName string) error {
+
+	workDir := fmt.Sprintf(WorkDirUrl, containerName)
+	if err := os.MkdirAll(workDir, 0700); err != nil {
+
+		return fmt.Errorf("mkdir %s error %v", workDir, err)
+	}
+
+	mntUrl := fmt.Sprintf(MntUrl, containerName)
+	upperDir := fmt.Sprintf(WriteLayerUrl, containerName)
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", strings.Join(lowerDirs, ":"), upperDir, workDir)
+
+	//挂载参数最长只有一页, 层数多的时候切换到各层共同的父目录, lowerdir 使用相对路径
+	if len(options) >= os.Getpagesize() {
+
+		parent := path.Dir(lowerDirs[0])
+		relativeDirs := make([]string, 0, len(lowerDirs))
+		for _, dir := range lowerDirs {
+
+			if path.Dir(dir) != parent {
+
+				return fmt.Errorf("too many layers to mount, lowerdir of %d layers is longer than a page", len(lowerDirs))
+			}
+			relativeDirs = append(relativeDirs, path.Base(dir))
+		}
+		options = fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", strings.Join(relativeDirs, ":"), upperDir, workDir)
+		if len(options) >= os.Getpagesize() {
+
+			return fmt.Errorf("too many layers to mount, lowerdir of %d layers is longer than a page", len(lowerDirs))
+		}
+
+		//工作目录是整个进程共用的, 挂载完马上切换回来, 其他地方都使用绝对路径
+		cwd, err := os.Getwd()
+		if err != nil {
+
+			return err
+		}
+		if err := os.Chdir(parent); err != nil {
+
+			return err
+		}
+		defer os.Chdir(cwd)
+	}
+
+	if err := syscall.Mount("overlay", mntUrl, "overlay", 0, options); err != nil {
+
+		return fmt.Errorf("mount overlay %s error %v", mntUrl, err)
+	}
+
+	return nil
+}
+
+func (d *Overlay2Driver) Remove(containerName string) error {
+
+	return os.RemoveAll(fmt.Sprintf(WorkDirUrl, containerName))
+}
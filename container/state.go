@@ -0,0 +1,71 @@
+package container
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 容器的状态
+var (
+	CREATED 			string = "created"
+	RUNNING 			string = "running"
+	PAUSED 				string = "paused"
+	STOP 				string = "stopped"
+	Exit 				string = "exited"
+	REMOVED 			string = "removed"
+)
+
+/*
+	容器的状态机, 每个状态可以转换到的状态
+	created -> running -> paused -> stopped -> removed
+	1.create 之后容器进程停在 init 管道上等待用户命令, start 之后才是 running
+	2.exited 是容器进程自己退出的状态, 和 stopped 一样可以被删除, 也可以用 start 重新启动
+	3.容器进程在任何状态下都可能自己退出, 所以 created, running, paused 都可以转换到 exited
+*/
+var transitions = map[string][]string{
+
+	CREATED: {RUNNING, STOP, Exit, REMOVED},
+	RUNNING: {PAUSED, STOP, Exit},
+	PAUSED:  {RUNNING, STOP, Exit},
+	STOP:    {RUNNING, REMOVED},
+	Exit:    {RUNNING, STOP, REMOVED},
+}
+
+//检查容器能否从当前状态转换到 to 状态, 不能转换时返回的错误中说明了容器当前的状态
+func CheckTransition(containerInfo *ContainerInfo, to string) error {
+
+	for _, status := range transitions[containerInfo.Status] {
+
+		if status == to {
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("container %s is %s, cannot change it to %s", containerInfo.Name, containerInfo.Status, to)
+}
+
+//容器当前是否处于 statuses 中的某个状态
+func HasStatus(containerInfo *ContainerInfo, statuses ...string) bool {
+
+	for _, status := range statuses {
+
+		if containerInfo.Status == status {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+//kill, exec 这类不改变容器状态的操作要求容器处于 statuses 中的某个状态, 错误的格式和 CheckTransition 一致
+func RequireStatus(containerInfo *ContainerInfo, action string, statuses ...string) error {
+
+	if HasStatus(containerInfo, statuses...) {
+
+		return nil
+	}
+
+	return fmt.Errorf("container %s is %s, %s requires it to be %s", containerInfo.Name, containerInfo.Status, action, strings.Join(statuses, " or "))
+}
@@ -0,0 +1,51 @@
+package container
+
+import (
+	"testing"
+)
+
+func TestRequireStatus(t *testing.T) {
+
+	containerInfo := &ContainerInfo{Name: "c1", Status: PAUSED}
+
+	if err := RequireStatus(containerInfo, "unpause", PAUSED); err != nil {
+
+		t.Errorf("unpause paused container error %v", err)
+	}
+
+	err := RequireStatus(containerInfo, "kill", RUNNING)
+	if err == nil || err.Error() != "container c1 is paused, kill requires it to be running" {
+
+		t.Errorf("kill paused container error = %v", err)
+	}
+
+	err = RequireStatus(containerInfo, "start", CREATED, STOP, Exit)
+	if err == nil || err.Error() != "container c1 is paused, start requires it to be created or stopped or exited" {
+
+		t.Errorf("start paused container error = %v", err)
+	}
+}
+
+func TestCheckTransition(t *testing.T) {
+
+	for _, test := range []struct {
+		from 	string
+		to 		string
+		ok 		bool
+	}{
+		{CREATED, RUNNING, true},
+		{RUNNING, PAUSED, true},
+		{PAUSED, RUNNING, true},
+		{Exit, RUNNING, true},
+		{RUNNING, REMOVED, false},
+		{PAUSED, REMOVED, false},
+		{STOP, PAUSED, false},
+	} {
+
+		err := CheckTransition(&ContainerInfo{Name: "c1", Status: test.from}, test.to)
+		if (err == nil) != test.ok {
+
+			t.Errorf("CheckTransition(%s, %s) error = %v", test.from, test.to, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+	存储驱动负责把镜像的只读层和容器的读写层联合挂载成容器的根目录
+	读写层统一放在 WriteLayerUrl 下, 驱动只负责挂载方式和自己额外需要的目录
+*/
+type StorageDriver interface {
+	Name() string												//驱动名
+	Mount(lowerDirs []string, containerName string) error		//把只读层(上层在前)和容器的读写层挂载到 MntUrl
+	Remove(containerName string) error							//删除驱动为容器创建的其他目录
+}
+
+var storageDrivers = map[string]StorageDriver{}
+
+//按优先级排列, 自动检测时使用内核支持的第一个驱动
+var storageDriverPriority = []string{"overlay2", "aufs"}
+
+func init() {
+
+	for _, driver := range []StorageDriver{&Overlay2Driver{}, &AufsDriver{}} {
+
+		storageDrivers[driver.Name()] = driver
+	}
+}
+
+//根据驱动名获取存储驱动, 没有记录驱动名的旧容器都是用 aufs 创建的
+func GetStorageDriver(name string) (StorageDriver, error) {
+
+	if name == "" {
+
+		name = "aufs"
+	}
+
+	driver, ok := storageDrivers[name]
+	if !ok {
+
+		return nil, fmt.Errorf("unknown storage driver %s, supported drivers are %s", name, strings.Join(storageDriverPriority, ", "))
+	}
+
+	return driver, nil
+}
+
+//从 /proc/filesystems 中检测内核支持的联合文件系统, 选出默认的存储驱动
+func DetectStorageDriver() (string, error) {
+
+	f, err := os.Open("/proc/filesystems")
+	if err != nil {
+
+		return "", err
+	}
+	defer f.Close()
+
+	//每一行的格式为 "nodev	overlay" 或者 "	ext4"
+	supported := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+
+			supported[fields[len(fields) - 1]] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+
+		return "", err
+	}
+
+	//overlay2 驱动使用的文件系统叫 overlay
+	filesystems := map[string]string{"overlay2": "overlay", "aufs": "aufs"}
+	for _, name := range storageDriverPriority {
+
+		if supported[filesystems[name]] {
+
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("neither overlay nor aufs is supported by the kernel")
+}
@@ -0,0 +1,119 @@
+package container
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+/*
+	--init 模式下 init 进程不再 exec 成用户命令, 而是留在容器内作为 1 号进程
+	1.PID namespace 中的 1 号进程不会收到没有注册处理函数的信号, 很多程序作为 1 号进程时收不到 stop 发送的 SIGTERM
+	  这里 init 注册了 forwardedSignals 中的信号, 收到之后转发给用户命令
+	2.容器内孤儿进程的父进程会变成 1 号进程, init 收到 SIGCHLD 时回收所有退出的子进程, 避免产生僵尸进程
+	3.用户命令退出后 init 以相同的退出码退出, 被信号杀掉时退出码为 128 + 信号值
+*/
+
+//转发给用户命令的信号
+//SIGCHLD 只用来回收子进程, SIGURG 是 Go runtime 抢占 goroutine 用的, SIGPIPE, SIGTTIN 和 SIGTTOU 是 init 自己读写时产生的, 都不转发
+var forwardedSignals = []os.Signal{
+	syscall.SIGHUP,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+	syscall.SIGTERM,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+	syscall.SIGALRM,
+	syscall.SIGWINCH,
+	syscall.SIGCONT,
+	syscall.SIGTSTP,
+}
+
+func runTinyInit(path string, argv []string, env []string) error {
+
+	//先注册信号再启动子进程, 避免子进程很快退出时漏掉 SIGCHLD
+	children := make(chan os.Signal, 1)
+	signal.Notify(children, syscall.SIGCHLD)
+	signals := make(chan os.Signal, 32)
+	signal.Notify(signals, forwardedSignals...)
+
+	cmd := exec.Command(path)
+	cmd.Args = argv
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	//-ti 时 init 和用户命令在同一个进程组中, 终端上的 Ctrl-C 会同时发给两者, init 再转发一次用户命令就会收到两次
+	//所以把用户命令放到单独的进程组中, 并设为终端的前台进程组, 终端产生的信号只发给用户命令
+	if isTerminal(os.Stdin.Fd()) {
+
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Foreground: true, Ctty: 0}
+	}
+
+	if err := cmd.Start(); err != nil {
+
+		log.Errorf("start %s error %v", path, err)
+		return err
+	}
+	childPid := cmd.Process.Pid
+
+	//子进程由下面的 wait4 回收, 不调用 cmd.Wait
+	//多个 SIGCHLD 可能合并成一个, 所以每次都回收所有已经退出的子进程
+	for {
+
+		select {
+
+		case <-children:
+			if exited, exitCode := reapChildren(childPid); exited {
+
+				os.Exit(exitCode)
+			}
+		case sig := <-signals:
+			if err := syscall.Kill(childPid, sig.(syscall.Signal)); err != nil && err != syscall.ESRCH {
+
+				log.Errorf("forward signal %v to %d error %v", sig, childPid, err)
+			}
+		}
+	}
+}
+
+//回收所有已经退出的子进程, 用户命令退出时返回它的退出码
+func reapChildren(childPid int) (bool, int) {
+
+	exited, exitCode := false, 0
+	for {
+
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if pid <= 0 || err != nil {
+
+			break
+		}
+
+		if pid == childPid {
+
+			exited = true
+			if status.Signaled() {
+
+				exitCode = 128 + int(status.Signal())
+			} else {
+
+				exitCode = status.ExitStatus()
+			}
+		}
+	}
+
+	return exited, exitCode
+}
+
+//能读取终端属性的文件描述符就是终端
+func isTerminal(fd uintptr) bool {
+
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+
+	return errno == 0
+}
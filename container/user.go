@@ -0,0 +1,124 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+/*
+	解析 -u 参数, 格式为 user[:group], user 和 group 可以是名字也可以是数字
+	名字从 rootfs 下的 /etc/passwd 和 /etc/group 中查找, 只指定 user 时使用 passwd 中的主组
+	数字在 passwd 中找不到时也可以使用, 这时主组为 0
+*/
+func LookupUser(rootfs string, user string) (int, int, error) {
+
+	ids := strings.SplitN(user, ":", 2)
+	if ids[0] == "" || (len(ids) == 2 && ids[1] == "") {
+
+		return 0, 0, fmt.Errorf("invalid user %s", user)
+	}
+
+	uid, gid := -1, 0
+	userNumber, userErr := strconv.Atoi(ids[0])
+
+	//passwd 每一行的格式为 name:password:uid:gid:gecos:home:shell
+	found := false
+	err := readColonFile(path.Join(rootfs, "/etc/passwd"), func(fields []string) bool {
+
+		if len(fields) < 4 {
+			return false
+		}
+		lineUid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return false
+		}
+		if fields[0] == ids[0] || (userErr == nil && lineUid == userNumber) {
+
+			uid = lineUid
+			gid, _ = strconv.Atoi(fields[3])
+			found = true
+			return true
+		}
+		return false
+	})
+	if !found {
+
+		if userErr != nil {
+
+			return 0, 0, fmt.Errorf("unable to find user %s: %v", ids[0], notFoundError(err))
+		}
+		uid = userNumber
+	}
+
+	if len(ids) == 1 {
+
+		return uid, gid, nil
+	}
+
+	if groupNumber, err := strconv.Atoi(ids[1]); err == nil {
+
+		return uid, groupNumber, nil
+	}
+
+	//group 每一行的格式为 name:password:gid:members
+	found = false
+	err = readColonFile(path.Join(rootfs, "/etc/group"), func(fields []string) bool {
+
+		if len(fields) < 3 || fields[0] != ids[1] {
+			return false
+		}
+		if lineGid, err := strconv.Atoi(fields[2]); err == nil {
+
+			gid = lineGid
+			found = true
+			return true
+		}
+		return false
+	})
+	if !found {
+
+		return 0, 0, fmt.Errorf("unable to find group %s: %v", ids[1], notFoundError(err))
+	}
+
+	return uid, gid, nil
+}
+
+//逐行读取以冒号分隔的文件, handler 返回 true 时停止读取
+func readColonFile(file string, handler func(fields []string) bool) error {
+
+	f, err := os.Open(file)
+	if err != nil {
+
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if handler(strings.Split(line, ":")) {
+
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
+
+func notFoundError(err error) error {
+
+	if err != nil {
+
+		return err
+	}
+
+	return fmt.Errorf("no matching entries")
+}
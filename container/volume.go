@@ -3,22 +3,38 @@ package container
 import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"syscall"
 )
 
-//Create a AUFS filesystem as container root workspace
-//创建一个aufs 文件系统作为容器的根　的工作目录
+//Create a union filesystem as container root workspace
+//用 storageDriver 指定的存储驱动创建一个联合文件系统作为容器的根　的工作目录
+//lowerDirs 是镜像各层解压之后的目录, 最上面的一层在前, 由镜像存储提供
 //为每个容器创建文件系统
-func NewWorkSpace(volume string, imageName string, containerName string){
+func NewWorkSpace(volume string, lowerDirs []string, containerName string, storageDriver string) error {
+
+	driver, err := GetStorageDriver(storageDriver)
+	if err != nil {
+
+		return err
+	}
 
-	//根据用户输入的镜像为每个容器创建只读层
-	CreateReadOnlyLayer(imageName)  //新建busybox 文件夹，将busybox.tar 解压到 busybox 目录下，作为容器的只读层
 	//为每个容器创建出一个可写层
 	CreateWriteLayer(containerName)    //创建了一个名为 writeLayer　的文件夹，　作为容器唯一的可写层
-	//创建容器的根目录，然后把镜像层的只读层和容器读写层挂载到容器根目录，成为容器的文件系统
-	CreateMountPoint(containerName, imageName) //创建了mnt 文件，作为挂载点，然后啊writeLayer目录和busybox 目录mount 到 mnt 目录下
+	//创建容器的根目录，然后把镜像的各个只读层和容器读写层挂载到容器根目录，成为容器的文件系统
+	if err := CreateMountPoint(containerName, lowerDirs, driver); err != nil { //创建了mnt 文件，作为挂载点，然后把 writeLayer目录和镜像的各层 mount 到 mnt 目录下
+
+		//只删除没有挂载上的挂载点, 重新启动的容器的可写层中还有数据, 由新建容器的调用者决定是否删除可写层
+		if err := removeMountPoint(fmt.Sprintf(MntUrl, containerName)); err != nil {
+
+			log.Errorf("remove mount point of container %s error %v", containerName, err)
+		}
+		return err
+	}
 
 	if volume != "" {
 
@@ -36,38 +52,6 @@ func NewWorkSpace(volume string, imageName string, containerName string){
 			log.Infof("volume parameter input is not correct.")
 		}
 	}
-}
-
-// 根据用户输入的镜像为每个容器创建只读层。 镜像解压出来的只读层以RootUrl + imageName 命名
-//根据tar 格式的镜像文件作为只读层
-func CreateReadOnlyLayer(imageName string)  error {
-
-	unTarFolderUrl := RootUrl + "/" + imageName + "/"
-	imageUrl := RootUrl + "/" + imageName + ".tar"
-
-	//判断这个 busybox 目录是否存在
-	exist, err := PathExists(unTarFolderUrl)
-	if err != nil {
-
-		log.Infof("Fail to judge whether dir %s exists %v", unTarFolderUrl, err)
-		return err
-	}
-
-	if !exist {
-		//如果目录不错在就创建这个目录
-		if err := os.MkdirAll(unTarFolderUrl, 0622); err != nil {
-
-			log.Errorf("mkdir dis %s error %v", unTarFolderUrl, err)
-			return err
-		}
-
-		//解压
-		if _, err := exec.Command("tar", "-xvf", imageUrl, "-C", unTarFolderUrl).CombinedOutput(); err != nil {
-
-			log.Errorf("Untar dis %s error %v", unTarFolderUrl, err)
-			return err
-		}
-	}
 
 	return nil
 }
@@ -83,8 +67,8 @@ func CreateWriteLayer(containerName string){
 }
 
 //创建容器的跟目录,然后把镜像只读层 和 容器读写层挂载到容器根目录，成为容器的文件系统
-//把通过镜像解压出来的只读层和容器的可读写层用AUFS联合挂载成为容器的文件系统
-func CreateMountPoint(containerName string, imageName string) error {
+//把镜像的各个只读层和容器的可读写层用存储驱动联合挂载成为容器的文件系统
+func CreateMountPoint(containerName string, lowerDirs []string, driver StorageDriver) error {
 
 	mntUrl := fmt.Sprintf(MntUrl, containerName)
 	if err := os.MkdirAll(mntUrl, 0777); err != nil {
@@ -92,16 +76,10 @@ func CreateMountPoint(containerName string, imageName string) error {
 		log.Errorf("mkdir dir %s is error %v", mntUrl, err)
 	}
 
-	tmpWriteLayer := fmt.Sprintf(WriteLayerUrl, containerName)
-	tmpImageLocation := RootUrl + "/" + imageName
-	mntURL := fmt.Sprintf(MntUrl, containerName)
-	dirs := "dirs=" + tmpWriteLayer + ":" + tmpImageLocation
+	//把镜像的各个只读层和容器的可读写层联合挂载称为容器的文件系统。
+	if err := driver.Mount(lowerDirs, containerName); err != nil {
 
-	//把通过镜像解压出来的只读层和容器的可读写层用aufs联合挂载称为容器的文件系统。
-	_, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", mntURL).CombinedOutput()
-	if err != nil {
-
-		log.Errorf("mount volume failed. %v", err)
+		log.Errorf("mount rootfs failed. %v", err)
 		return err
 	}
 
@@ -128,11 +106,10 @@ func MountVolume(volumeURLs []string, containerName string) error {
 
 		log.Infof("mkdir container dir %s error. %v", containerVolumeURL, err)
 	}
-	dirs := "dirs=" + parentUrl
 
-	//最后把宿主机文件目录挂载到容器挂载点，　这样启动容器的过程，对数据卷的处理也就完成了
-	_, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", containerVolumeURL).CombinedOutput()
-	if err != nil {
+	//最后把宿主机文件目录 bind mount 到容器挂载点，　这样启动容器的过程，对数据卷的处理也就完成了
+	//bind mount 不依赖存储驱动, overlay 和 aufs 都可以使用
+	if err := syscall.Mount(parentUrl, containerVolumeURL, "", syscall.MS_BIND, ""); err != nil {
 
 		log.Errorf("mount volume failed. %v", err)
 		return err
@@ -142,9 +119,24 @@ func MountVolume(volumeURLs []string, containerName string) error {
 }
 
 
-//Delete the AUFS filesystem while container exit
-//当　容器退出的时候，　删除aufs　文件系统
-func DeleteWorkSpace(volume string, containerName string){
+//Delete the union filesystem while container exit
+//当　容器退出的时候，　删除容器的文件系统, storageDriver 是创建容器时使用的存储驱动
+func DeleteWorkSpace(volume string, containerName string, storageDriver string){
+
+	UnmountWorkSpace(volume, containerName)
+	DeleteWriteLayer(containerName)
+
+	if driver, err := GetStorageDriver(storageDriver); err != nil {
+
+		log.Errorf("get storage driver error %v", err)
+	} else if err := driver.Remove(containerName); err != nil {
+
+		log.Errorf("remove %s storage of container %s error %v", driver.Name(), containerName, err)
+	}
+}
+
+//卸载容器的文件系统和数据卷, 保留容器的可写层, 容器重启时在可写层上重新挂载
+func UnmountWorkSpace(volume string, containerName string){
 
 	if volume != "" {
 
@@ -162,8 +154,6 @@ func DeleteWorkSpace(volume string, containerName string){
 
 		DeleteMountPoint(containerName)
 	}
-
-	DeleteWriteLayer(containerName)
 }
 
 //删除挂载点
@@ -221,6 +211,52 @@ func DeleteMountPointWithVolume(volumeURLs []string, containerName string) error
 	return nil
 }
 
+//挂载失败时挂载点上可能还留着挂载了一部分的文件系统, 先卸载, 再用 os.Remove 删除空的挂载点
+//不能用 os.RemoveAll, 否则会穿过挂载点删掉镜像层或者可写层中的文件
+func removeMountPoint(mntUrl string) error {
+
+	mounted, err := isMountPoint(mntUrl)
+	if err != nil {
+
+		return err
+	}
+	if mounted {
+
+		if err := syscall.Unmount(mntUrl, syscall.MNT_DETACH); err != nil {
+
+			return fmt.Errorf("unmount %s error %v", mntUrl, err)
+		}
+	}
+
+	if err := os.Remove(mntUrl); err != nil && !os.IsNotExist(err) {
+
+		return err
+	}
+	return nil
+}
+
+//在 /proc/self/mountinfo 中查找 dir 是否是一个挂载点, 第 5 列是挂载点的路径
+func isMountPoint(dir string) (bool, error) {
+
+	content, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+
+		return false, err
+	}
+
+	dir = filepath.Clean(dir)
+	for _, line := range strings.Split(string(content), "\n") {
+
+		fields := strings.Fields(line)
+		if len(fields) > 4 && fields[4] == dir {
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
 func PathExists(path string ) (bool, error ){
 
 	_, err := os.Stat(path)
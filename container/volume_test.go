@@ -0,0 +1,85 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+)
+
+func TestRemoveMountPoint(t *testing.T) {
+
+	if mounted, err := isMountPoint("/"); err != nil || !mounted {
+
+		t.Errorf("isMountPoint(/) = %v, %v, want true", mounted, err)
+	}
+
+	//没有挂载的空目录直接删除
+	empty := path.Join(t.TempDir(), "mnt")
+	if err := os.Mkdir(empty, 0755); err != nil {
+
+		t.Fatal(err)
+	}
+	if mounted, err := isMountPoint(empty); err != nil || mounted {
+
+		t.Errorf("isMountPoint(%s) = %v, %v, want false", empty, mounted, err)
+	}
+	if err := removeMountPoint(empty); err != nil {
+
+		t.Errorf("remove empty mount point error %v", err)
+	}
+	if _, err := os.Stat(empty); !os.IsNotExist(err) {
+
+		t.Errorf("empty mount point still exists: %v", err)
+	}
+
+	//挂载点中还有文件时不删除, 文件原样保留
+	dir := path.Join(t.TempDir(), "mnt")
+	file := path.Join(dir, "keep")
+	if err := os.Mkdir(dir, 0755); err != nil {
+
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte("data"), 0644); err != nil {
+
+		t.Fatal(err)
+	}
+	if err := removeMountPoint(dir); err == nil {
+
+		t.Errorf("expect error removing a non empty mount point")
+	}
+	if _, err := os.Stat(file); err != nil {
+
+		t.Errorf("file under mount point removed: %v", err)
+	}
+
+	//已经挂载的目录先卸载, 挂载进来的文件不受影响
+	src := t.TempDir()
+	if err := ioutil.WriteFile(path.Join(src, "layer"), []byte("data"), 0644); err != nil {
+
+		t.Fatal(err)
+	}
+	mnt := path.Join(t.TempDir(), "mnt")
+	if err := os.Mkdir(mnt, 0755); err != nil {
+
+		t.Fatal(err)
+	}
+	if err := syscall.Mount(src, mnt, "", syscall.MS_BIND, ""); err != nil {
+
+		t.Skipf("bind mount not permitted: %v", err)
+	}
+	if err := removeMountPoint(mnt); err != nil {
+
+		syscall.Unmount(mnt, syscall.MNT_DETACH)
+		t.Fatalf("remove mounted mount point error %v", err)
+	}
+	if _, err := os.Stat(mnt); !os.IsNotExist(err) {
+
+		t.Errorf("mount point still exists: %v", err)
+	}
+	if _, err := os.Stat(path.Join(src, "layer")); err != nil {
+
+		t.Errorf("file of the mounted directory removed: %v", err)
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"io/ioutil"
-	_ "ttdocker/nsenter"
+	"ttdocker/nsenter"
 	"os"
 	"os/exec"
 	"strings"
@@ -14,8 +14,24 @@ import (
 
 const ENV_EXEC_PID = "ttdocker_pid"
 const ENV_EXEC_CMD = "ttdocker_cmd"
+const ENV_EXEC_USER = "ttdocker_user"
+const ENV_EXEC_CWD = "ttdocker_cwd"
 
-func ExecContainer(containerName string, comArray []string){
+//user 和 workingDir 为空时使用 run 的 -u 和 -w 指定的用户和工作目录
+func ExecContainer(containerName string, comArray []string, user string, workingDir string){
+
+	//被冻结的容器中的进程不会被调度, 进入之后命令也无法执行
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+
+		log.Errorf("exec container get container %s info error %v", containerName, err)
+		return
+	}
+	if err := container.RequireStatus(containerInfo, "exec", container.RUNNING); err != nil {
+
+		log.Errorf("exec container %s error %v", containerName, err)
+		return
+	}
 
 	pid, err := GetContainerPidByName(containerName)
 	if err != nil {
@@ -24,9 +40,8 @@ func ExecContainer(containerName string, comArray []string){
 		return
 	}
 
-	cmdStr := strings.Join(comArray, " ")
 	log.Infof("container pid %s ", pid)
-	log.Infof("command %s", cmdStr)
+	log.Infof("command %q", comArray)
 
 	cmd := exec.Command("/proc/self/exe", "exec")
 	cmd.Stdin = os.Stdin
@@ -34,7 +49,33 @@ func ExecContainer(containerName string, comArray []string){
 	cmd.Stderr = os.Stderr
 
 	os.Setenv(ENV_EXEC_PID, pid)
-	os.Setenv(ENV_EXEC_CMD, cmdStr)
+	//命令的每个参数单独编码, nsenter 解析之后直接 execvp, 不经过容器内的 shell
+	os.Setenv(ENV_EXEC_CMD, nsenter.EncodeArgs(comArray))
+
+	if user == "" {
+
+		user = containerInfo.User
+	}
+	if workingDir == "" {
+
+		workingDir = containerInfo.WorkingDir
+	}
+
+	//nsenter 不进入 user namespace, 用户名在宿主机上按容器的 rootfs 解析成 uid:gid
+	if user != "" {
+
+		uid, gid, err := container.LookupUser(fmt.Sprintf(container.MntUrl, containerName), user)
+		if err != nil {
+
+			log.Errorf("exec container %s error %v", containerName, err)
+			return
+		}
+		os.Setenv(ENV_EXEC_USER, fmt.Sprintf("%d:%d", uid, gid))
+	}
+	if workingDir != "" {
+
+		os.Setenv(ENV_EXEC_CWD, workingDir)
+	}
 
 	//获取对应的PID环境变量， 其实也就是容器的环境变量
 	containerEnvs := getEnvsByPid(pid)
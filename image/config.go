@@ -0,0 +1,311 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//镜像中记录的默认运行参数, 字段和 OCI 镜像配置中的 config 一致, 导入和保存时直接使用
+//run 时命令行参数优先, 没有指定的才使用镜像中的配置
+type Config struct {
+	User 			string `json:"User,omitempty"`
+	ExposedPorts 	map[string]struct{} `json:"ExposedPorts,omitempty"`  //镜像声明的端口, 例如 80/tcp, 只用于显示
+	Env 			[]string `json:"Env,omitempty"`
+	Entrypoint 		[]string `json:"Entrypoint,omitempty"`
+	Cmd 			[]string `json:"Cmd,omitempty"`
+	WorkingDir 		string `json:"WorkingDir,omitempty"`
+}
+
+//镜像声明的端口, 按端口排序
+func (c *Config) Ports() []string {
+
+	if c == nil {
+
+		return nil
+	}
+
+	ports := make([]string, 0, len(c.ExposedPorts))
+	for port := range c.ExposedPorts {
+
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+
+	return ports
+}
+
+/*
+	在 base 的基础上执行 commit --change 指定的 Dockerfile 指令, 返回新的配置, base 不变
+	支持 CMD, ENTRYPOINT, ENV, WORKDIR, USER 和 EXPOSE
+	CMD 和 ENTRYPOINT 可以是 json 数组, 也可以是 shell 格式, shell 格式通过 /bin/sh -c 运行
+*/
+func ApplyChanges(base *Config, changes []string) (*Config, error) {
+
+	if base == nil && len(changes) == 0 {
+
+		return nil, nil
+	}
+
+	config := &Config{}
+	if base != nil {
+
+		*config = *base
+		config.Env = append([]string{}, base.Env...)
+		config.Entrypoint = append([]string{}, base.Entrypoint...)
+		config.Cmd = append([]string{}, base.Cmd...)
+		config.ExposedPorts = map[string]struct{}{}
+		for port := range base.ExposedPorts {
+
+			config.ExposedPorts[port] = struct{}{}
+		}
+	}
+
+	for _, change := range changes {
+
+		if err := applyChange(config, change); err != nil {
+
+			return nil, fmt.Errorf("invalid change %q: %v", change, err)
+		}
+	}
+
+	return config, nil
+}
+
+func applyChange(config *Config, change string) error {
+
+	fields := strings.SplitN(strings.TrimSpace(change), " ", 2)
+	if len(fields) < 2 || strings.TrimSpace(fields[1]) == "" {
+
+		return fmt.Errorf("missing arguments")
+	}
+	args := strings.TrimSpace(fields[1])
+
+	switch strings.ToUpper(fields[0]) {
+
+	case "CMD":
+		cmd, err := parseCommand(args)
+		if err != nil {
+
+			return err
+		}
+		config.Cmd = cmd
+	case "ENTRYPOINT":
+		entrypoint, err := parseCommand(args)
+		if err != nil {
+
+			return err
+		}
+		config.Entrypoint = entrypoint
+	case "ENV":
+		envs, err := parseEnvChange(args)
+		if err != nil {
+
+			return err
+		}
+		config.Env = MergeEnv(config.Env, envs)
+	case "WORKDIR":
+		//和 Dockerfile 一样, 相对路径相对于之前的工作目录
+		if !path.IsAbs(args) {
+
+			args = path.Join("/", config.WorkingDir, args)
+		}
+		config.WorkingDir = path.Clean(args)
+	case "USER":
+		config.User = args
+	case "EXPOSE":
+		if config.ExposedPorts == nil {
+
+			config.ExposedPorts = map[string]struct{}{}
+		}
+		for _, port := range strings.Fields(args) {
+
+			if !strings.Contains(port, "/") {
+
+				port += "/tcp"
+			}
+			config.ExposedPorts[port] = struct{}{}
+		}
+	default:
+		return fmt.Errorf("unsupported instruction %s, supported: CMD, ENTRYPOINT, ENV, WORKDIR, USER, EXPOSE", fields[0])
+	}
+
+	return nil
+}
+
+//["sh", "-c", "echo"] 格式直接使用, 其他的当作 shell 命令
+func parseCommand(args string) ([]string, error) {
+
+	if strings.HasPrefix(args, "[") {
+
+		var cmd []string
+		if err := json.Unmarshal([]byte(args), &cmd); err != nil {
+
+			return nil, fmt.Errorf("decode %s error %v", args, err)
+		}
+		return cmd, nil
+	}
+
+	return []string{"/bin/sh", "-c", args}, nil
+}
+
+//ENV 支持 key=value key2=value2 和 key value 两种格式
+//和 Dockerfile 一样, 值可以用引号包起来, 例如 A="x y" B='a b', 也可以用 \ 转义空格
+func parseEnvChange(args string) ([]string, error) {
+
+	words, err := splitWords(args)
+	if err != nil {
+
+		return nil, err
+	}
+	if !strings.Contains(words[0], "=") {
+
+		if len(words) < 2 {
+
+			return nil, fmt.Errorf("ENV %s has no value", words[0])
+		}
+		//key value 格式中 key 后面的内容都是值, 保留其中的空格
+		value, err := unquote(strings.TrimSpace(strings.TrimPrefix(args, words[0])))
+		if err != nil {
+
+			return nil, err
+		}
+		return []string{words[0] + "=" + value}, nil
+	}
+
+	var envs []string
+	for _, word := range words {
+
+		kv := strings.SplitN(word, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+
+			return nil, fmt.Errorf("invalid ENV %s, format: key=value", word)
+		}
+		value, err := unquote(kv[1])
+		if err != nil {
+
+			return nil, err
+		}
+		envs = append(envs, kv[0] + "=" + value)
+	}
+
+	return envs, nil
+}
+
+//按照引号之外的空白拆分, 引号和转义字符保留在拆分结果中, 由 unquote 处理
+func splitWords(s string) ([]string, error) {
+
+	var words []string
+	start := -1
+	var quote rune
+	escaped := false
+	for i, r := range s {
+
+		if start < 0 {
+
+			if unicode.IsSpace(r) {
+				continue
+			}
+			start = i
+		}
+
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case unicode.IsSpace(r):
+			words = append(words, s[start:i])
+			start = -1
+		}
+	}
+	if quote != 0 {
+
+		return nil, fmt.Errorf("unterminated quote in %s", s)
+	}
+	if start >= 0 {
+
+		words = append(words, s[start:])
+	}
+	if len(words) == 0 {
+
+		return nil, fmt.Errorf("missing arguments")
+	}
+
+	return words, nil
+}
+
+//去掉引号并处理转义, 单引号中的内容原样保留, 双引号中和引号之外可以用 \ 转义
+func unquote(s string) (string, error) {
+
+	var b strings.Builder
+	var quote rune
+	escaped := false
+	for _, r := range s {
+
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				b.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				b.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if quote != 0 || escaped {
+
+		return "", fmt.Errorf("unterminated quote or escape in %s", s)
+	}
+
+	return b.String(), nil
+}
+
+//把 overrides 中的环境变量合并到 base 中, 同名的变量使用 overrides 中的值, 顺序不变
+func MergeEnv(base []string, overrides []string) []string {
+
+	merged := append([]string{}, base...)
+	for _, env := range overrides {
+
+		key := strings.SplitN(env, "=", 2)[0]
+		replaced := false
+		for i, old := range merged {
+
+			if strings.SplitN(old, "=", 2)[0] == key {
+
+				merged[i] = env
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+
+			merged = append(merged, env)
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,60 @@
+package image
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseEnvChange(t *testing.T) {
+
+	tests := []struct {
+		args 	string
+		want 	[]string
+	}{
+		{`A=1`, []string{"A=1"}},
+		{`A=1 B=2`, []string{"A=1", "B=2"}},
+		{`A="x y"`, []string{"A=x y"}},
+		{`A="x y" B='a  b' C=3`, []string{"A=x y", "B=a  b", "C=3"}},
+		{`A=x\ y`, []string{"A=x y"}},
+		{`A="say \"hi\""`, []string{`A=say "hi"`}},
+		{`A='$HOME \n'`, []string{`A=$HOME \n`}},
+		{`A=`, []string{"A="}},
+		{`A x  y`, []string{"A=x  y"}},
+		{`A "x y"`, []string{"A=x y"}},
+	}
+
+	for _, test := range tests {
+
+		got, err := parseEnvChange(test.args)
+		if err != nil {
+
+			t.Errorf("parseEnvChange(%s) error %v", test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+
+			t.Errorf("parseEnvChange(%s) = %q, want %q", test.args, got, test.want)
+		}
+	}
+
+	for _, args := range []string{`A`, `A="x y`, `=1`, `A=1 B`, `A='x`} {
+
+		if got, err := parseEnvChange(args); err == nil {
+
+			t.Errorf("parseEnvChange(%s) = %q, want error", args, got)
+		}
+	}
+}
+
+func TestApplyEnvChange(t *testing.T) {
+
+	config, err := ApplyChanges(&Config{Env: []string{"A=old", "PATH=/bin"}}, []string{`ENV A="x y" B=1`})
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if want := []string{"A=x y", "PATH=/bin", "B=1"}; !reflect.DeepEqual(config.Env, want) {
+
+		t.Errorf("Env = %q, want %q", config.Env, want)
+	}
+}
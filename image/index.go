@@ -0,0 +1,212 @@
+package image
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+//镜像列表中的一项, 一个镜像有多个镜像名时每个镜像名一项, 没有镜像名的镜像显示为 <none>
+type Summary struct {
+	Repository 	string `json:"repository"`
+	Tag 		string `json:"tag"`
+	ID 			string `json:"id"`
+	Size 		int64 `json:"size"`  //镜像各层 tar 包大小之和, 和其他镜像共用的层也计算在内
+	Created 	string `json:"created"`
+}
+
+//列出镜像存储中的所有镜像, 最新创建的在前
+func List() ([]*Summary, error) {
+
+	files, err := ioutil.ReadDir(imagePath)
+	if err != nil {
+
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	repositories, err := loadRepositories()
+	if err != nil {
+
+		return nil, err
+	}
+	names := map[string][]string{}
+	for name, id := range repositories {
+
+		names[id] = append(names[id], name)
+	}
+
+	var summaries []*Summary
+	for _, file := range files {
+
+		if !strings.HasSuffix(file.Name(), ".json") || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		img, err := loadImage(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+
+			return nil, err
+		}
+
+		size := imageSize(img)
+		if len(names[img.ID]) == 0 {
+
+			summaries = append(summaries, &Summary{Repository: "<none>", Tag: "<none>", ID: img.ID, Size: size, Created: img.Created})
+			continue
+		}
+		for _, name := range names[img.ID] {
+
+			repository, tag := splitName(name)
+			summaries = append(summaries, &Summary{Repository: repository, Tag: tag, ID: img.ID, Size: size, Created: img.Created})
+		}
+	}
+
+	//commit 记录的是本地时区的时间, load 记录的是镜像配置中的 UTC 时间, 解析之后再比较
+	sort.Slice(summaries, func(i, j int) bool {
+
+		ti, tj := parseCreated(summaries[i].Created), parseCreated(summaries[j].Created)
+		if !ti.Equal(tj) {
+
+			return ti.After(tj)
+		}
+		if summaries[i].Repository != summaries[j].Repository {
+
+			return summaries[i].Repository < summaries[j].Repository
+		}
+		return summaries[i].Tag < summaries[j].Tag
+	})
+
+	return summaries, nil
+}
+
+//创建时间是 RFC3339 格式, 可以带纳秒, 解析失败的排在最后
+func parseCreated(created string) time.Time {
+
+	t, err := time.Parse(time.RFC3339Nano, created)
+	if err != nil {
+
+		return time.Time{}
+	}
+
+	return t
+}
+
+func imageSize(img *Image) int64 {
+
+	var size int64
+	for _, layer := range img.Layers {
+
+		if fi, err := os.Stat(path.Join(blobPath, digestHex(layer))); err == nil {
+
+			size += fi.Size()
+		}
+	}
+
+	return size
+}
+
+/*
+	删除镜像记录和指向它的镜像名, 再删除不再被任何镜像使用的层
+	调用者要先确认没有容器使用这个镜像
+*/
+func Delete(id string) error {
+
+	names, err := References(id)
+	if err != nil {
+
+		return err
+	}
+	for _, name := range names {
+
+		if err := RemoveReference(name); err != nil {
+
+			return err
+		}
+	}
+
+	//持有排他锁, 等正在进行的 commit 和 load 写完镜像记录之后再检查哪些层没有被使用
+	unlock, err := lockStore(true)
+	if err != nil {
+
+		return err
+	}
+	defer unlock()
+
+	if err := os.Remove(path.Join(imagePath, digestHex(id) + ".json")); err != nil {
+
+		return fmt.Errorf("remove image %s error %v", id, err)
+	}
+
+	return removeUnusedLayers()
+}
+
+//层目录和 tar 包只在没有镜像使用时删除, 其他镜像共用的层保留
+func removeUnusedLayers() error {
+
+	files, err := ioutil.ReadDir(imagePath)
+	if err != nil {
+
+		return err
+	}
+	used := map[string]bool{}
+	for _, file := range files {
+
+		if !strings.HasSuffix(file.Name(), ".json") || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		img, err := loadImage(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+
+			return err
+		}
+		for _, layer := range img.Layers {
+
+			used[digestHex(layer)] = true
+		}
+	}
+
+	for _, dir := range []string{layerPath, aufsLayerPath, blobPath} {
+
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		//以 . 开头的是正在解压或者写入的临时文件
+		for _, entry := range entries {
+
+			if used[entry.Name()] || strings.HasPrefix(entry.Name(), ".") || path.Join(dir, entry.Name()) == shortLinkPath {
+				continue
+			}
+			if err := os.RemoveAll(path.Join(dir, entry.Name())); err != nil {
+
+				return fmt.Errorf("remove layer %s error %v", entry.Name(), err)
+			}
+		}
+	}
+
+	//删除指向已经删除的层的短链接
+	links, err := ioutil.ReadDir(shortLinkPath)
+	if err != nil && !os.IsNotExist(err) {
+
+		return err
+	}
+	for _, link := range links {
+
+		if _, err := os.Stat(path.Join(shortLinkPath, link.Name())); os.IsNotExist(err) {
+
+			os.Remove(path.Join(shortLinkPath, link.Name()))
+		}
+	}
+
+	return nil
+}
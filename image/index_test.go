@@ -0,0 +1,25 @@
+package image
+
+import (
+	"testing"
+)
+
+//commit 写入本地时区的时间, load 写入 UTC 时间, 按字符串比较会排错顺序
+func TestParseCreated(t *testing.T) {
+
+	local := "2026-10-18T15:30:00.123456789+08:00"  //UTC 07:30
+	utc := "2026-10-18T08:00:00Z"
+
+	if !parseCreated(utc).After(parseCreated(local)) {
+
+		t.Errorf("%s should be after %s", utc, local)
+	}
+	if !parseCreated("2026-10-18T07:30:00Z").Before(parseCreated(local)) {
+
+		t.Errorf("nanoseconds are not compared")
+	}
+	if !parseCreated("").IsZero() || !parseCreated("yesterday").IsZero() {
+
+		t.Errorf("invalid created time should be zero")
+	}
+}
@@ -0,0 +1,525 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+/*
+	层的 tar 包使用 OCI 的 whiteout 格式
+	.wh.<name> 表示删除了下层的 name, .wh..wh..opq 表示这个目录不显示下层的内容
+	overlay2 使用的解压目录转换成 overlay 的格式, 被删除的文件是设备号为 0/0 的字符设备, 不透明的目录有 trusted.overlay.opaque=y 属性
+	aufs 的 whiteout 格式和 OCI 相同, 解压时原样保留
+*/
+const (
+	//和 docker 一样, 一个镜像最多 125 层, 短链接的 lowerdir 参数不会超过一页
+	MaxLayers = 125
+	shortLinkLength = 26
+
+	whiteoutPrefix = ".wh."
+	whiteoutOpaqueDir = ".wh..wh..opq"
+	overlayOpaqueXattr = "trusted.overlay.opaque"
+)
+
+//把容器的读写层打包成一个新的层, 只包含容器相对于镜像的改动
+func createLayer(diffDir string, storageDriver string) (string, error) {
+
+	reader, writer := io.Pipe()
+	go func() {
+
+		writer.CloseWithError(writeLayerTar(diffDir, storageDriver, writer))
+	}()
+
+	digest, err := storeLayer(reader)
+	reader.CloseWithError(err)
+
+	return digest, err
+}
+
+//保存一个层的 tar 包并解压, 已经有相同内容的层时直接使用原来的层, 返回层的 digest
+//tar 包可以是 gzip 压缩的, 保存的是解压之后的 tar 包
+func storeLayer(r io.Reader) (string, error) {
+
+	layerReader, err := decompress(r)
+	if err != nil {
+
+		return "", err
+	}
+
+	if err := os.MkdirAll(blobPath, 0700); err != nil {
+
+		return "", err
+	}
+	tmpBlob, err := ioutil.TempFile(blobPath, ".tmp-")
+	if err != nil {
+
+		return "", err
+	}
+	defer os.Remove(tmpBlob.Name())
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmpBlob, hash), layerReader)
+	if closeErr := tmpBlob.Close(); err == nil {
+
+		err = closeErr
+	}
+	if err != nil {
+
+		return "", fmt.Errorf("save layer error %v", err)
+	}
+
+	digest := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	blobFile := path.Join(blobPath, digestHex(digest))
+	if _, err := os.Stat(blobFile); os.IsNotExist(err) {
+
+		if err := os.Rename(tmpBlob.Name(), blobFile); err != nil {
+
+			return "", err
+		}
+	}
+
+	//overlay2 使用的目录先解压出来, 层的内容有问题时在 commit 和 load 时就能发现
+	return digest, unpackLayer(digest, "overlay2")
+}
+
+//overlay2 以外的驱动(aufs, 以及没有记录驱动的旧容器)直接使用 .wh. 格式的 whiteout
+func overlayWhiteouts(storageDriver string) bool {
+
+	return storageDriver == "overlay2"
+}
+
+//根据文件头判断 tar 包是否被压缩, 返回解压之后的内容
+func decompress(r io.Reader) (io.Reader, error) {
+
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(4)
+	if err != nil {
+
+		//不到 4 个字节的内容不可能是压缩过的
+		return buffered, nil
+	}
+
+	switch {
+
+	case magic[0] == 0x1f && magic[1] == 0x8b:
+		return gzip.NewReader(buffered)
+	case magic[0] == 0x28 && magic[1] == 0xb5 && magic[2] == 0x2f && magic[3] == 0xfd:
+		return nil, fmt.Errorf("zstd compressed tar is not supported")
+	}
+
+	return buffered, nil
+}
+
+//把层的 tar 包按 storageDriver 使用的 whiteout 格式解压, 已经解压过的层不再解压
+//先解压到临时目录再改名, 解压失败时不会留下不完整的层
+func unpackLayer(digest string, storageDriver string) error {
+
+	dest := layerDir(digest, storageDriver)
+	if _, err := os.Stat(dest); err == nil {
+
+		return linkLayer(digest, storageDriver)
+	}
+
+	blob, err := os.Open(path.Join(blobPath, digestHex(digest)))
+	if err != nil {
+
+		return err
+	}
+	defer blob.Close()
+
+	if err := os.MkdirAll(path.Dir(dest), 0700); err != nil {
+
+		return err
+	}
+	tmpDir, err := ioutil.TempDir(path.Dir(dest), ".tmp-")
+	if err != nil {
+
+		return err
+	}
+	//TempDir 创建的目录权限是 0700, commit 生成的层中没有根目录的条目, 这里使用普通目录的权限
+	if err := os.Chmod(tmpDir, 0755); err != nil {
+
+		os.RemoveAll(tmpDir)
+		return err
+	}
+
+	if err := extractLayer(blob, tmpDir, overlayWhiteouts(storageDriver)); err != nil {
+
+		os.RemoveAll(tmpDir)
+		return fmt.Errorf("unpack layer %s error %v", digest, err)
+	}
+	if err := os.Rename(tmpDir, dest); err != nil {
+
+		return err
+	}
+
+	return linkLayer(digest, storageDriver)
+}
+
+//为 overlay2 使用的解压目录创建短链接, 以前解压的层没有短链接, 使用时补上
+func linkLayer(digest string, storageDriver string) error {
+
+	if !overlayWhiteouts(storageDriver) {
+
+		return nil
+	}
+
+	link := shortLayerLink(digest)
+	if _, err := os.Lstat(link); err == nil {
+
+		return nil
+	}
+	if err := os.MkdirAll(shortLinkPath, 0700); err != nil {
+
+		return err
+	}
+	//使用相对路径, 整个镜像存储目录移动之后链接仍然有效
+	if err := os.Symlink(path.Join("..", digestHex(digest)), link); err != nil && !os.IsExist(err) {
+
+		return fmt.Errorf("link layer %s error %v", digest, err)
+	}
+
+	return nil
+}
+
+//解压层的 tar 包, overlayWhiteout 为 true 时把 OCI 格式的 whiteout 转换成 overlay 的格式
+func extractLayer(r io.Reader, dest string, overlayWhiteout bool) error {
+
+	tr := tar.NewReader(r)
+	var dirs []*tar.Header
+
+	for {
+
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+
+			return err
+		}
+
+		//去掉 .. 之类的路径, 保证所有文件都在 dest 之下
+		name := path.Clean("/" + hdr.Name)
+		target := filepath.Join(dest, name)
+		if name == "/" {
+
+			if hdr.Typeflag == tar.TypeDir {
+
+				dirs = append(dirs, hdr)
+			}
+			continue
+		}
+
+		parent := filepath.Dir(target)
+		if err := checkNoSymlink(dest, parent); err != nil {
+
+			return err
+		}
+		if err := os.MkdirAll(parent, 0755); err != nil {
+
+			return err
+		}
+
+		base := filepath.Base(name)
+		if overlayWhiteout && base == whiteoutOpaqueDir {
+
+			if err := syscall.Setxattr(parent, overlayOpaqueXattr, []byte("y"), 0); err != nil {
+
+				return fmt.Errorf("set opaque dir %s error %v", parent, err)
+			}
+			continue
+		}
+		if overlayWhiteout && strings.HasPrefix(base, whiteoutPrefix) {
+
+			whiteout := filepath.Join(parent, strings.TrimPrefix(base, whiteoutPrefix))
+			os.RemoveAll(whiteout)
+			if err := syscall.Mknod(whiteout, syscall.S_IFCHR, 0); err != nil {
+
+				return fmt.Errorf("create whiteout %s error %v", whiteout, err)
+			}
+			continue
+		}
+
+		//同一个 tar 包中后面的条目覆盖前面的条目, 只有两个都是目录时才保留原来的目录
+		if fi, err := os.Lstat(target); err == nil && !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+
+			if err := os.RemoveAll(target); err != nil {
+
+				return err
+			}
+		}
+
+		if err := createEntry(dest, target, hdr, tr); err != nil {
+
+			return err
+		}
+
+		if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+
+			return fmt.Errorf("chown %s error %v", target, err)
+		}
+		if hdr.Typeflag == tar.TypeSymlink {
+			continue
+		}
+		//chown 会清掉 setuid 位, 所以在 chown 之后设置权限
+		if err := os.Chmod(target, hdr.FileInfo().Mode()); err != nil {
+
+			return fmt.Errorf("chmod %s error %v", target, err)
+		}
+
+		//目录的修改时间在解压它下面的文件时会改变, 全部解压完之后再设置
+		if hdr.Typeflag == tar.TypeDir {
+
+			dirs = append(dirs, hdr)
+		} else if hdr.Typeflag != tar.TypeLink {
+
+			os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+		}
+	}
+
+	for _, hdr := range dirs {
+
+		target := filepath.Join(dest, path.Clean("/" + hdr.Name))
+		if path.Clean("/" + hdr.Name) == "/" {
+
+			os.Lchown(target, hdr.Uid, hdr.Gid)
+			os.Chmod(target, hdr.FileInfo().Mode())
+		}
+		os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+	}
+
+	return nil
+}
+
+//按 tar 条目的类型创建文件
+func createEntry(dest string, target string, hdr *tar.Header, r io.Reader) error {
+
+	switch hdr.Typeflag {
+
+	case tar.TypeDir:
+		if err := os.Mkdir(target, 0755); err != nil && !os.IsExist(err) {
+
+			return err
+		}
+
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+
+			return err
+		}
+		_, err = io.Copy(f, r)
+		if closeErr := f.Close(); err == nil {
+
+			err = closeErr
+		}
+		if err != nil {
+
+			return err
+		}
+
+	case tar.TypeSymlink:
+		//符号链接的目标只在容器内解析, 这里原样保存
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+
+			return err
+		}
+
+	case tar.TypeLink:
+		linkTarget := filepath.Join(dest, path.Clean("/" + hdr.Linkname))
+		if err := checkNoSymlink(dest, filepath.Dir(linkTarget)); err != nil {
+
+			return err
+		}
+		if err := os.Link(linkTarget, target); err != nil {
+
+			return err
+		}
+
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		mode := uint32(hdr.Mode & 07777)
+		switch hdr.Typeflag {
+
+		case tar.TypeChar:
+			mode |= syscall.S_IFCHR
+		case tar.TypeBlock:
+			mode |= syscall.S_IFBLK
+		default:
+			mode |= syscall.S_IFIFO
+		}
+		if err := syscall.Mknod(target, mode, int(mkdev(hdr.Devmajor, hdr.Devminor))); err != nil {
+
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unsupported tar entry %s type %c", hdr.Name, hdr.Typeflag)
+	}
+
+	return nil
+}
+
+//检查 dest 下的 dir 路径中没有符号链接, 避免 tar 包通过符号链接把文件写到 dest 之外
+func checkNoSymlink(dest string, dir string) error {
+
+	rel, err := filepath.Rel(dest, dir)
+	if err != nil || rel == "." {
+
+		return err
+	}
+
+	current := dest
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+
+		current = filepath.Join(current, part)
+		fi, err := os.Lstat(current)
+		if err != nil {
+
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+
+			return fmt.Errorf("invalid layer, path %s goes through symlink %s", dir, current)
+		}
+	}
+
+	return nil
+}
+
+//和 glibc 的 makedev 一致
+func mkdev(major int64, minor int64) uint64 {
+
+	return uint64(minor&0xff) | uint64(major&0xfff)<<8 | uint64(minor&^0xff)<<12 | uint64(major&^0xfff)<<32
+}
+
+//把读写层打包成 tar 包, 删除的文件转换成 OCI 格式的 whiteout
+func writeLayerTar(diffDir string, storageDriver string, w io.Writer) error {
+
+	tw := tar.NewWriter(w)
+
+	err := filepath.Walk(diffDir, func(file string, info os.FileInfo, err error) error {
+
+		if err != nil {
+
+			return err
+		}
+
+		rel, err := filepath.Rel(diffDir, file)
+		if err != nil || rel == "." {
+
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		base := info.Name()
+
+		//tar 包不能保存 socket, 容器重启之后也用不到
+		if info.Mode()&os.ModeSocket != 0 {
+			return nil
+		}
+
+		//aufs 读写层中 .wh..wh. 开头的是 aufs 自己使用的目录, 只有 .wh..wh..opq 表示不透明的目录
+		if storageDriver != "overlay2" && strings.HasPrefix(base, whiteoutPrefix + whiteoutPrefix) && base != whiteoutOpaqueDir {
+
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		//overlay 中被删除的文件是设备号为 0/0 的字符设备
+		if storageDriver == "overlay2" && info.Mode()&os.ModeCharDevice != 0 {
+
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Rdev == 0 {
+
+				return tw.WriteHeader(&tar.Header{
+					Name: path.Join(path.Dir(name), whiteoutPrefix + base),
+					Typeflag: tar.TypeReg,
+					Mode: 0600,
+					ModTime: info.ModTime(),
+				})
+			}
+		}
+
+		linkTarget := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+
+			if linkTarget, err = os.Readlink(file); err != nil {
+
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, linkTarget)
+		if err != nil {
+
+			return err
+		}
+		hdr.Name = name
+		if info.IsDir() {
+
+			hdr.Name += "/"
+		}
+		//tar 包中只记录 uid 和 gid, 用户名在不同的镜像中可能对应不同的 ID
+		hdr.Uname, hdr.Gname = "", ""
+
+		if err := tw.WriteHeader(hdr); err != nil {
+
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+
+			f, err := os.Open(file)
+			if err != nil {
+
+				return err
+			}
+			_, err = io.Copy(tw, f)
+			f.Close()
+			if err != nil {
+
+				return err
+			}
+		}
+
+		//overlay 中不透明的目录有 trusted.overlay.opaque=y 属性
+		if storageDriver == "overlay2" && info.IsDir() {
+
+			value := make([]byte, 1)
+			if n, err := syscall.Getxattr(file, overlayOpaqueXattr, value); err == nil && n == 1 && value[0] == 'y' {
+
+				return tw.WriteHeader(&tar.Header{
+					Name: path.Join(name, whiteoutOpaqueDir),
+					Typeflag: tar.TypeReg,
+					Mode: 0600,
+					ModTime: info.ModTime(),
+				})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+
+		logrus.Errorf("write layer tar of %s error %v", diffDir, err)
+		return err
+	}
+
+	return tw.Close()
+}
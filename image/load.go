@@ -0,0 +1,375 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+//docker save 新版本的 tar 包中层的路径为 blobs/sha256/<hex>, 路径中就有层的 digest
+var blobPathPattern = regexp.MustCompile("^blobs/sha256/([0-9a-f]{64})$")
+
+/*
+	导入 OCI 镜像格式(index.json)或者 docker save 生成(manifest.json)的 tar 包
+	1.tar 包先解压到镜像存储下的临时目录
+	2.检查每个 blob 的 sha256 和描述符中的 digest 一致, 每一层解压之后的 digest 和配置中的 diff_ids 一致
+	3.层中 .wh. 开头的 whiteout 在保存层时转换成 overlay 的格式
+*/
+func Load(archive string) error {
+
+	f, err := os.Open(archive)
+	if err != nil {
+
+		return err
+	}
+	defer f.Close()
+
+	//保存各层到写入镜像记录期间持有镜像存储的共享锁, 避免同时执行的 rmi 删掉刚保存的层
+	unlock, err := lockStore(false)
+	if err != nil {
+
+		return err
+	}
+	defer unlock()
+
+	dir, err := ioutil.TempDir(defaultStorePath, ".load-")
+	if err != nil {
+
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := decompress(f)
+	if err != nil {
+
+		return err
+	}
+	//镜像 tar 包本身不是层, 不转换 whiteout
+	if err := extractLayer(r, dir, false); err != nil {
+
+		return fmt.Errorf("unpack %s error %v", archive, err)
+	}
+
+	//新版本 docker save 同时生成两种格式, manifest.json 中有镜像名, 优先使用
+	if _, err := os.Stat(path.Join(dir, "manifest.json")); err == nil {
+
+		return loadDockerArchive(dir)
+	}
+	if _, err := os.Stat(path.Join(dir, "index.json")); err == nil {
+
+		return loadOCILayout(dir)
+	}
+
+	return fmt.Errorf("%s is neither an OCI image layout nor a docker save archive", archive)
+}
+
+func loadDockerArchive(dir string) error {
+
+	var manifests []dockerManifest
+	if err := readJSON(dir, "manifest.json", &manifests); err != nil {
+
+		return err
+	}
+
+	for _, manifest := range manifests {
+
+		var config imageConfig
+		if err := readJSON(dir, manifest.Config, &config); err != nil {
+
+			return err
+		}
+		if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+
+			return fmt.Errorf("image config %s has %d diff ids but there are %d layers", manifest.Config, len(config.RootFS.DiffIDs), len(manifest.Layers))
+		}
+
+		var layers []string
+		for i, layer := range manifest.Layers {
+
+			blobDigest := ""
+			if match := blobPathPattern.FindStringSubmatch(layer); match != nil {
+
+				blobDigest = "sha256:" + match[1]
+			}
+			digest, err := importLayer(dir, layer, blobDigest, config.RootFS.DiffIDs[i])
+			if err != nil {
+
+				return err
+			}
+			layers = append(layers, digest)
+		}
+
+		if err := registerLoadedImage(layers, config, manifest.RepoTags); err != nil {
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+func loadOCILayout(dir string) error {
+
+	var index ociIndex
+	if err := readJSON(dir, "index.json", &index); err != nil {
+
+		return err
+	}
+
+	for _, desc := range index.Manifests {
+
+		manifestDesc, err := resolveManifest(dir, desc)
+		if err != nil {
+
+			return err
+		}
+
+		var manifest ociManifest
+		if err := readBlobJSON(dir, manifestDesc.Digest, &manifest); err != nil {
+
+			return err
+		}
+		var config imageConfig
+		if err := readBlobJSON(dir, manifest.Config.Digest, &config); err != nil {
+
+			return err
+		}
+		if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+
+			return fmt.Errorf("image config %s has %d diff ids but there are %d layers", manifest.Config.Digest, len(config.RootFS.DiffIDs), len(manifest.Layers))
+		}
+
+		var layers []string
+		for i, layer := range manifest.Layers {
+
+			if strings.Contains(layer.MediaType, "zstd") {
+
+				return fmt.Errorf("layer %s: zstd compressed layers are not supported", layer.Digest)
+			}
+			blobFile, err := blobFileOf(layer.Digest)
+			if err != nil {
+
+				return err
+			}
+			digest, err := importLayer(dir, blobFile, layer.Digest, config.RootFS.DiffIDs[i])
+			if err != nil {
+
+				return err
+			}
+			layers = append(layers, digest)
+		}
+
+		//ref.name 可能只是一个 tag, 只有完整的镜像名才作为镜像名
+		var names []string
+		if name := desc.Annotations[annotationImageName]; name != "" {
+
+			names = append(names, name)
+		} else if ref := desc.Annotations[annotationRefName]; strings.ContainsAny(ref, ":/") {
+
+			names = append(names, ref)
+		}
+
+		if err := registerLoadedImage(layers, config, names); err != nil {
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+//index.json 中的描述符也可能指向多平台镜像的 manifest list, 这时选择当前平台的镜像
+func resolveManifest(dir string, desc descriptor) (descriptor, error) {
+
+	if desc.MediaType != mediaTypeOCIIndex && desc.MediaType != mediaTypeDockerList {
+
+		return desc, nil
+	}
+
+	var index ociIndex
+	if err := readBlobJSON(dir, desc.Digest, &index); err != nil {
+
+		return desc, err
+	}
+	for _, manifest := range index.Manifests {
+
+		if manifest.Platform == nil || (manifest.Platform.OS == "linux" && manifest.Platform.Architecture == runtime.GOARCH) {
+
+			return manifest, nil
+		}
+	}
+
+	return desc, fmt.Errorf("no image for linux/%s in %s", runtime.GOARCH, desc.Digest)
+}
+
+//检查 blob 的 digest, 保存并解压这一层, 再检查解压之后的 digest 和 diffID 一致
+func importLayer(dir string, name string, blobDigest string, diffID string) (string, error) {
+
+	file, err := archivePath(dir, name)
+	if err != nil {
+
+		return "", err
+	}
+	if blobDigest != "" {
+
+		if err := verifyDigest(file, blobDigest); err != nil {
+
+			return "", err
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+
+		return "", err
+	}
+	defer f.Close()
+
+	//storeLayer 会解压 gzip 压缩的层, 返回的是解压之后的 digest
+	digest, err := storeLayer(f)
+	if err != nil {
+
+		return "", fmt.Errorf("layer %s: %v", name, err)
+	}
+	if digest != diffID {
+
+		return "", fmt.Errorf("layer %s diff id mismatch, expected %s but got %s", name, diffID, digest)
+	}
+
+	return digest, nil
+}
+
+//创建导入的镜像并设置镜像名, 和 docker load 一样输出导入的镜像
+func registerLoadedImage(layers []string, config imageConfig, names []string) error {
+
+	created := config.Created
+	if created == "" {
+
+		created = time.Now().Format(time.RFC3339Nano)
+	}
+	img, err := createImage(layers, "", created, config.Config)
+	if err != nil {
+
+		return err
+	}
+
+	if len(names) == 0 {
+
+		fmt.Printf("Loaded image ID: %s\n", img.ID)
+		return nil
+	}
+	for _, name := range names {
+
+		if err := SetReference(name, img.ID); err != nil {
+
+			return err
+		}
+		fmt.Printf("Loaded image: %s\n", name)
+	}
+
+	return nil
+}
+
+//tar 包中的路径对应的文件, docker save 中的 layer.tar 可能是指向其他层的符号链接, 解析之后仍然要在 dir 之下
+func archivePath(dir string, name string) (string, error) {
+
+	file, err := filepath.EvalSymlinks(filepath.Join(dir, path.Clean("/" + name)))
+	if err != nil {
+
+		return "", fmt.Errorf("%s not found in archive: %v", name, err)
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+
+		return "", err
+	}
+	if !strings.HasPrefix(file, dir + string(filepath.Separator)) {
+
+		return "", fmt.Errorf("%s points outside of the archive", name)
+	}
+
+	return file, nil
+}
+
+//只支持 sha256 的 digest
+func blobFileOf(digest string) (string, error) {
+
+	if !strings.HasPrefix(digest, "sha256:") || len(digestHex(digest)) != 64 {
+
+		return "", fmt.Errorf("unsupported digest %s", digest)
+	}
+
+	return path.Join("blobs", "sha256", digestHex(digest)), nil
+}
+
+func verifyDigest(file string, expected string) error {
+
+	f, err := os.Open(file)
+	if err != nil {
+
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+
+		return err
+	}
+	if actual := "sha256:" + hex.EncodeToString(hash.Sum(nil)); actual != expected {
+
+		return fmt.Errorf("blob digest mismatch, expected %s but got %s", expected, actual)
+	}
+
+	return nil
+}
+
+func readJSON(dir string, name string, v interface{}) error {
+
+	file, err := archivePath(dir, name)
+	if err != nil {
+
+		return err
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+
+		return err
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+
+		return fmt.Errorf("decode %s error %v", name, err)
+	}
+
+	return nil
+}
+
+//读取 blob 之前先检查它的 digest
+func readBlobJSON(dir string, digest string, v interface{}) error {
+
+	name, err := blobFileOf(digest)
+	if err != nil {
+
+		return err
+	}
+	file, err := archivePath(dir, name)
+	if err != nil {
+
+		return err
+	}
+	if err := verifyDigest(file, digest); err != nil {
+
+		return err
+	}
+
+	return readJSON(dir, name, v)
+}
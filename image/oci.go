@@ -0,0 +1,62 @@
+package image
+
+//OCI 镜像格式和 docker save 格式中用到的结构, 只定义 load 和 save 需要的字段
+const (
+	mediaTypeOCIIndex 		= "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest 	= "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIConfig 		= "application/vnd.oci.image.config.v1+json"
+	mediaTypeOCILayer 		= "application/vnd.oci.image.layer.v1.tar"
+	mediaTypeDockerList 	= "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	annotationRefName 		= "org.opencontainers.image.ref.name"
+	annotationImageName 	= "io.containerd.image.name"  //containerd 和 docker 保存完整的镜像名
+)
+
+//指向一个 blob 的描述符
+type descriptor struct {
+	MediaType 	string `json:"mediaType"`
+	Digest 		string `json:"digest"`
+	Size 		int64 `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform 	*platform `json:"platform,omitempty"`
+}
+
+type platform struct {
+	Architecture string `json:"architecture"`
+	OS 			 string `json:"os"`
+}
+
+//index.json, 也用于多平台镜像的 manifest list
+type ociIndex struct {
+	SchemaVersion 	int `json:"schemaVersion"`
+	MediaType 		string `json:"mediaType,omitempty"`
+	Manifests 		[]descriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	SchemaVersion 	int `json:"schemaVersion"`
+	MediaType 		string `json:"mediaType,omitempty"`
+	Config 			descriptor `json:"config"`
+	Layers 			[]descriptor `json:"layers"`
+}
+
+//镜像的配置, config 是默认的运行参数, diff_ids 是每一层解压之后的 tar 包的 digest
+type imageConfig struct {
+	Created 		string `json:"created,omitempty"`
+	Architecture 	string `json:"architecture"`
+	OS 				string `json:"os"`
+	Config 			*Config `json:"config,omitempty"`
+	RootFS 			rootFS `json:"rootfs"`
+}
+
+type rootFS struct {
+	Type 		string `json:"type"`
+	DiffIDs 	[]string `json:"diff_ids"`
+}
+
+//docker save 生成的 manifest.json 中的一项, 路径都是相对于 tar 包根目录的
+type dockerManifest struct {
+	Config 		string
+	RepoTags 	[]string
+	Layers 		[]string
+}
@@ -0,0 +1,214 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"time"
+)
+
+/*
+	把镜像保存成 OCI 镜像格式的 tar 包
+	同时生成 docker save 格式的 manifest.json, docker load 也可以导入
+	保存的层就是镜像存储中未压缩的 tar 包, 层的 digest 和 diff_id 相同
+*/
+func Save(names []string, output string) error {
+
+	if len(names) == 0 {
+
+		return fmt.Errorf("no image to save")
+	}
+
+	if err := os.MkdirAll(path.Dir(output), 0755); err != nil {
+
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(path.Dir(output), ".save-")
+	if err != nil {
+
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	err = writeImageArchive(names, tmpFile)
+	if closeErr := tmpFile.Close(); err == nil {
+
+		err = closeErr
+	}
+	if err != nil {
+
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), output)
+}
+
+func writeImageArchive(names []string, w io.Writer) error {
+
+	tw := tar.NewWriter(w)
+	for _, dir := range []string{"blobs/", "blobs/sha256/"} {
+
+		if err := tw.WriteHeader(&tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Unix(0, 0)}); err != nil {
+
+			return err
+		}
+	}
+
+	index := ociIndex{SchemaVersion: 2, MediaType: mediaTypeOCIIndex, Manifests: []descriptor{}}
+	dockerManifests := []dockerManifest{}
+	//多个镜像共用的层只保存一次
+	written := map[string]bool{}
+
+	for _, name := range names {
+
+		img, err := Get(name)
+		if err != nil {
+
+			return err
+		}
+
+		manifest := ociManifest{SchemaVersion: 2, MediaType: mediaTypeOCIManifest}
+		dockerEntry := dockerManifest{}
+		for _, layer := range img.Layers {
+
+			blobFile := path.Join(blobPath, digestHex(layer))
+			fi, err := os.Stat(blobFile)
+			if err != nil {
+
+				return fmt.Errorf("layer %s of image %s error %v", layer, name, err)
+			}
+			if !written[layer] {
+
+				if err := writeBlobFile(tw, layer, blobFile, fi.Size()); err != nil {
+
+					return err
+				}
+				written[layer] = true
+			}
+
+			manifest.Layers = append(manifest.Layers, descriptor{MediaType: mediaTypeOCILayer, Digest: layer, Size: fi.Size()})
+			dockerEntry.Layers = append(dockerEntry.Layers, "blobs/sha256/" + digestHex(layer))
+		}
+
+		config := imageConfig{
+			Created: img.Created,
+			Architecture: runtime.GOARCH,
+			OS: "linux",
+			Config: img.Config,
+			RootFS: rootFS{Type: "layers", DiffIDs: img.Layers},
+		}
+		manifest.Config, err = writeBlobJSON(tw, mediaTypeOCIConfig, config, written)
+		if err != nil {
+
+			return err
+		}
+		manifestDesc, err := writeBlobJSON(tw, mediaTypeOCIManifest, manifest, written)
+		if err != nil {
+
+			return err
+		}
+
+		//按镜像名保存时记录补全了 tag 的镜像名, 按镜像 ID 保存时没有镜像名
+		dockerEntry.Config = "blobs/sha256/" + digestHex(manifest.Config.Digest)
+		if IsReference(name) {
+
+			fullName, err := normalizeName(name)
+			if err != nil {
+
+				return err
+			}
+			_, tag := splitName(fullName)
+			manifestDesc.Annotations = map[string]string{
+				annotationImageName: fullName,
+				annotationRefName: tag,
+			}
+			dockerEntry.RepoTags = []string{fullName}
+		}
+
+		index.Manifests = append(index.Manifests, manifestDesc)
+		dockerManifests = append(dockerManifests, dockerEntry)
+	}
+
+	files := []struct {
+		name string
+		content interface{}
+	}{
+		{"oci-layout", map[string]string{"imageLayoutVersion": "1.0.0"}},
+		{"index.json", index},
+		{"manifest.json", dockerManifests},
+	}
+	for _, file := range files {
+
+		content, err := json.Marshal(file.content)
+		if err != nil {
+
+			return err
+		}
+		if err := writeTarFile(tw, file.name, content); err != nil {
+
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+//把 json 编码之后的内容作为 blob 写入 tar 包, 返回指向它的描述符
+func writeBlobJSON(tw *tar.Writer, mediaType string, v interface{}, written map[string]bool) (descriptor, error) {
+
+	content, err := json.Marshal(v)
+	if err != nil {
+
+		return descriptor{}, err
+	}
+	sum := sha256.Sum256(content)
+	desc := descriptor{MediaType: mediaType, Digest: "sha256:" + hex.EncodeToString(sum[:]), Size: int64(len(content))}
+
+	if !written[desc.Digest] {
+
+		if err := writeTarFile(tw, "blobs/sha256/" + digestHex(desc.Digest), content); err != nil {
+
+			return descriptor{}, err
+		}
+		written[desc.Digest] = true
+	}
+
+	return desc, nil
+}
+
+func writeBlobFile(tw *tar.Writer, digest string, file string, size int64) error {
+
+	f, err := os.Open(file)
+	if err != nil {
+
+		return err
+	}
+	defer f.Close()
+
+	if err := tw.WriteHeader(&tar.Header{Name: "blobs/sha256/" + digestHex(digest), Typeflag: tar.TypeReg, Mode: 0644, Size: size, ModTime: time.Unix(0, 0)}); err != nil {
+
+		return err
+	}
+	_, err = io.Copy(tw, f)
+
+	return err
+}
+
+//tar 包中文件的修改时间固定, 同样的镜像保存出来的 tar 包相同
+func writeTarFile(tw *tar.Writer, name string, content []byte) error {
+
+	if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content)), ModTime: time.Unix(0, 0)}); err != nil {
+
+		return err
+	}
+	_, err := tw.Write(content)
+
+	return err
+}
@@ -0,0 +1,522 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	"ttdocker/container"
+)
+
+/*
+	镜像存储的目录结构
+	blobs/sha256/<hex>		每一层的 tar 包, 以未压缩 tar 包的 sha256 命名, 相同内容的层只保存一份
+	layers/<hex>			每一层解压之后的目录, 所有使用这一层的镜像和容器共用, 作为联合挂载的只读层, whiteout 是 overlay 的格式
+	layers/l/<短 ID>			指向 layers/<hex> 的符号链接, 短 ID 是 hex 的前 26 位, 挂载 overlay 时使用, 否则 lowerdir 太长
+	layers-aufs/<hex>		aufs 使用的解压目录, whiteout 保持 tar 包中 .wh. 的格式, aufs 容器第一次使用时才解压
+	images/<hex>.json		镜像的记录, 镜像 ID 是记录内容的 sha256
+	repositories.json		镜像名到镜像 ID 的映射
+	.lock					镜像存储的文件锁
+*/
+var (
+	defaultStorePath = path.Join(container.RootUrl, "images")
+	blobPath 		 = path.Join(defaultStorePath, "blobs", "sha256")
+	layerPath 		 = path.Join(defaultStorePath, "layers")
+	aufsLayerPath 	 = path.Join(defaultStorePath, "layers-aufs")
+	imagePath 		 = path.Join(defaultStorePath, "images")
+	repositoriesFile = path.Join(defaultStorePath, "repositories.json")
+	lockFile 		 = path.Join(defaultStorePath, ".lock")
+
+	shortLinkPath 	 = path.Join(layerPath, "l")
+
+	//镜像 ID 的前缀也可以用来查找镜像, 至少 6 位
+	idPrefixPattern = regexp.MustCompile("^(sha256:)?[0-9a-f]{6,64}$")
+	//和 docker 一样, 镜像名由小写字母、数字和分隔符组成, 前面可以有 registry 的地址和端口, tag 最长 128 位
+	namePattern = regexp.MustCompile(`^([a-zA-Z0-9]+([.-][a-zA-Z0-9]+)*(:[0-9]+)?/)?[a-z0-9]+(([._]|__|-+)[a-z0-9]+)*(/[a-z0-9]+(([._]|__|-+)[a-z0-9]+)*)*$`)
+	tagPattern = regexp.MustCompile("^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$")
+)
+
+//一个镜像就是按顺序叠加的多个只读层
+type Image struct {
+	ID 			string `json:"id"`  //sha256:<hex>
+	Layers 		[]string `json:"layers"`  //每一层的 digest, 最下面的一层在前
+	Created 	string `json:"created"`  //创建时间, RFC3339 格式
+	Container 	string `json:"container,omitempty"`  //commit 生成的镜像记录来源容器的 ID
+	Config 		*Config `json:"config,omitempty"`  //默认的运行参数, 没有时为空
+}
+
+//按镜像名或者镜像 ID 查找镜像
+//以前的镜像是 RootUrl 下的 <镜像名>.tar, 第一次使用时导入成只有一层的镜像
+func Get(ref string) (*Image, error) {
+
+	img, err := Find(ref)
+	if err == nil {
+
+		return img, nil
+	}
+
+	legacyTar := path.Join(container.RootUrl, ref + ".tar")
+	if _, statErr := os.Stat(legacyTar); statErr == nil {
+
+		return importLegacyImage(ref, legacyTar)
+	}
+
+	return nil, err
+}
+
+//只在镜像存储中查找镜像, 不导入旧的 <镜像名>.tar, rmi 和 images 使用
+func Find(ref string) (*Image, error) {
+
+	repositories, err := loadRepositories()
+	if err != nil {
+
+		return nil, err
+	}
+	if name, err := normalizeName(ref); err == nil {
+
+		if id, ok := repositories[name]; ok {
+
+			return loadImage(id)
+		}
+	}
+
+	if idPrefixPattern.MatchString(ref) {
+
+		if img, err := loadImageByPrefix(strings.TrimPrefix(ref, "sha256:")); err == nil {
+
+			return img, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unable to find image %s", ref)
+}
+
+//镜像各层在 storageDriver 下挂载时使用的目录, 最上面的一层在前, 和 overlay 的 lowerdir 顺序一致
+//overlay2 使用的是指向解压目录的短链接, 挂载之前要先调用 Unpack 保证这些目录都已经解压好了
+//同一个层出现多次时只保留最上面的一个, overlay 不能挂载重复的目录, 而下面那个层的内容和 whiteout 都被上面的覆盖了
+func (img *Image) LayerDirs(storageDriver string) []string {
+
+	dirs := make([]string, 0, len(img.Layers))
+	seen := map[string]bool{}
+	for i := len(img.Layers) - 1; i >= 0; i-- {
+
+		if seen[img.Layers[i]] {
+			continue
+		}
+		seen[img.Layers[i]] = true
+		dirs = append(dirs, mountLayerDir(img.Layers[i], storageDriver))
+	}
+
+	return dirs
+}
+
+//按 storageDriver 使用的 whiteout 格式解压镜像中还没有解压的层
+func (img *Image) Unpack(storageDriver string) error {
+
+	for _, layer := range img.Layers {
+
+		if err := unpackLayer(layer, storageDriver); err != nil {
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+//把容器的读写层 diffDir 保存成新的一层, 叠加在 parent 的各层之上, 和运行参数一起创建一个镜像
+//storageDriver 是创建读写层时使用的存储驱动, 不同驱动记录删除文件的方式不同
+func Create(parent *Image, diffDir string, storageDriver string, containerID string, config *Config) (*Image, error) {
+
+	unlock, err := lockStore(false)
+	if err != nil {
+
+		return nil, err
+	}
+	defer unlock()
+
+	digest, err := createLayer(diffDir, storageDriver)
+	if err != nil {
+
+		return nil, fmt.Errorf("create layer from %s error %v", diffDir, err)
+	}
+
+	layers := append(append([]string{}, parent.Layers...), digest)
+	return createImage(layers, containerID, time.Now().Format(time.RFC3339Nano), config)
+}
+
+/*
+	镜像存储的文件锁, 关闭返回的函数时释放
+	1.commit 和 load 从保存层到写入镜像记录期间持有共享锁, 可以同时进行
+	2.删除没有使用的层时持有排他锁, 这样不会把刚保存好, 还没有镜像记录引用的层当成没有使用的层删掉
+*/
+func lockStore(exclusive bool) (func(), error) {
+
+	if err := os.MkdirAll(defaultStorePath, 0700); err != nil {
+
+		return nil, err
+	}
+	f, err := os.OpenFile(lockFile, os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+
+		return nil, fmt.Errorf("open image store lock error %v", err)
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+
+		f.Close()
+		return nil, fmt.Errorf("lock image store error %v", err)
+	}
+
+	//关闭文件时内核释放 flock 加的锁
+	return func() { f.Close() }, nil
+}
+
+//导入的镜像使用镜像配置中的创建时间, 同一个镜像导入多次得到的镜像 ID 相同
+func createImage(layers []string, containerID string, created string, config *Config) (*Image, error) {
+
+	if len(layers) > MaxLayers {
+
+		return nil, fmt.Errorf("image has %d layers, more than the maximum %d layers", len(layers), MaxLayers)
+	}
+
+	img := &Image{
+		Layers: layers,
+		Created: created,
+		Container: containerID,
+		Config: config,
+	}
+
+	//ID 是不包含 ID 字段的记录内容的 sha256
+	content, err := json.Marshal(img)
+	if err != nil {
+
+		return nil, err
+	}
+	sum := sha256.Sum256(content)
+	img.ID = "sha256:" + hex.EncodeToString(sum[:])
+
+	content, err = json.Marshal(img)
+	if err != nil {
+
+		return nil, err
+	}
+	if err := writeFileAtomic(path.Join(imagePath, digestHex(img.ID) + ".json"), content); err != nil {
+
+		return nil, fmt.Errorf("save image %s error %v", img.ID, err)
+	}
+
+	return img, nil
+}
+
+//让镜像名指向某个镜像, 镜像名已经存在时指向新的镜像, 没有 tag 时使用 latest
+func SetReference(name string, id string) error {
+
+	name, err := normalizeName(name)
+	if err != nil {
+
+		return err
+	}
+
+	repositories, err := loadRepositories()
+	if err != nil {
+
+		return err
+	}
+	repositories[name] = id
+
+	return saveRepositories(repositories)
+}
+
+//删除一个镜像名, 镜像本身不删除
+func RemoveReference(name string) error {
+
+	name, err := normalizeName(name)
+	if err != nil {
+
+		return err
+	}
+
+	repositories, err := loadRepositories()
+	if err != nil {
+
+		return err
+	}
+	if _, ok := repositories[name]; !ok {
+
+		return fmt.Errorf("no such image name %s", name)
+	}
+	delete(repositories, name)
+
+	return saveRepositories(repositories)
+}
+
+//ref 是否是一个已有的镜像名, 而不是镜像 ID
+func IsReference(ref string) bool {
+
+	name, err := normalizeName(ref)
+	if err != nil {
+
+		return false
+	}
+	repositories, err := loadRepositories()
+	if err != nil {
+
+		return false
+	}
+	_, ok := repositories[name]
+
+	return ok
+}
+
+//指向某个镜像的所有镜像名, 按名字排序
+func References(id string) ([]string, error) {
+
+	repositories, err := loadRepositories()
+	if err != nil {
+
+		return nil, err
+	}
+
+	var names []string
+	for name, imageID := range repositories {
+
+		if imageID == id {
+
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+/*
+	把镜像名统一成 name:tag 的格式, 没有 tag 时使用 latest
+	registry 的端口也带冒号, 例如 localhost:5000/app, 最后一个 / 之后的冒号才是 tag
+*/
+func normalizeName(name string) (string, error) {
+
+	repository, tag := name, "latest"
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+
+		repository, tag = name[:i], name[i + 1:]
+	}
+
+	if !namePattern.MatchString(repository) {
+
+		return "", fmt.Errorf("invalid image name %s, only lowercase letters, digits and separators are allowed", name)
+	}
+	if !tagPattern.MatchString(tag) {
+
+		return "", fmt.Errorf("invalid image tag %s", tag)
+	}
+
+	return repository + ":" + tag, nil
+}
+
+//把 name:tag 拆成镜像名和 tag
+func splitName(name string) (string, string) {
+
+	i := strings.LastIndex(name, ":")
+
+	return name[:i], name[i + 1:]
+}
+
+//把旧的 <镜像名>.tar 导入成只有一层的镜像, 以后直接使用镜像存储中的层
+func importLegacyImage(name string, tarFile string) (*Image, error) {
+
+	f, err := os.Open(tarFile)
+	if err != nil {
+
+		return nil, err
+	}
+	defer f.Close()
+
+	unlock, err := lockStore(false)
+	if err != nil {
+
+		return nil, err
+	}
+	defer unlock()
+
+	logrus.Infof("import image %s from %s", name, tarFile)
+	digest, err := storeLayer(f)
+	if err != nil {
+
+		return nil, fmt.Errorf("import image %s error %v", name, err)
+	}
+
+	img, err := createImage([]string{digest}, "", time.Now().Format(time.RFC3339Nano), nil)
+	if err != nil {
+
+		return nil, err
+	}
+
+	return img, SetReference(name, img.ID)
+}
+
+func loadImage(id string) (*Image, error) {
+
+	content, err := ioutil.ReadFile(path.Join(imagePath, digestHex(id) + ".json"))
+	if err != nil {
+
+		return nil, fmt.Errorf("load image %s error %v", id, err)
+	}
+
+	var img Image
+	if err := json.Unmarshal(content, &img); err != nil {
+
+		return nil, fmt.Errorf("decode image %s error %v", id, err)
+	}
+
+	return &img, nil
+}
+
+//按 ID 前缀查找镜像, 前缀对应多个镜像时报错
+func loadImageByPrefix(prefix string) (*Image, error) {
+
+	files, err := ioutil.ReadDir(imagePath)
+	if err != nil {
+
+		return nil, err
+	}
+
+	var matched []string
+	for _, file := range files {
+
+		if strings.HasSuffix(file.Name(), ".json") && strings.HasPrefix(file.Name(), prefix) {
+
+			matched = append(matched, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+
+	switch len(matched) {
+
+	case 0:
+		return nil, fmt.Errorf("unable to find image %s", prefix)
+	case 1:
+		return loadImage(matched[0])
+	default:
+		return nil, fmt.Errorf("image id prefix %s is ambiguous", prefix)
+	}
+}
+
+func loadRepositories() (map[string]string, error) {
+
+	repositories := map[string]string{}
+	content, err := ioutil.ReadFile(repositoriesFile)
+	if err != nil {
+
+		if os.IsNotExist(err) {
+			return repositories, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, &repositories); err != nil {
+
+		return nil, fmt.Errorf("decode %s error %v", repositoriesFile, err)
+	}
+
+	//以前 commit 和导入旧镜像时记录的镜像名没有 tag, 统一加上 latest
+	for name, id := range repositories {
+
+		if normalized, err := normalizeName(name); err == nil && normalized != name {
+
+			delete(repositories, name)
+			if _, ok := repositories[normalized]; !ok {
+
+				repositories[normalized] = id
+			}
+		}
+	}
+
+	return repositories, nil
+}
+
+func saveRepositories(repositories map[string]string) error {
+
+	content, err := json.Marshal(repositories)
+	if err != nil {
+
+		return err
+	}
+
+	return writeFileAtomic(repositoriesFile, content)
+}
+
+//先写临时文件再改名, 其他进程不会读到写了一半的文件
+func writeFileAtomic(file string, content []byte) error {
+
+	if err := os.MkdirAll(path.Dir(file), 0700); err != nil {
+
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(path.Dir(file), ".tmp-")
+	if err != nil {
+
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(content); err != nil {
+
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), file)
+}
+
+//sha256:<hex> 中的 hex 部分, 用作文件名
+func digestHex(digest string) string {
+
+	return strings.TrimPrefix(digest, "sha256:")
+}
+
+func layerDir(digest string, storageDriver string) string {
+
+	if overlayWhiteouts(storageDriver) {
+
+		return path.Join(layerPath, digestHex(digest))
+	}
+
+	return path.Join(aufsLayerPath, digestHex(digest))
+}
+
+//overlay 的挂载参数最长只有一页, 用短链接代替完整的层目录, 和 docker 的 overlay2 一样
+func shortLayerLink(digest string) string {
+
+	return path.Join(shortLinkPath, digestHex(digest)[:shortLinkLength])
+}
+
+//挂载时使用的层目录, overlay2 使用短链接
+func mountLayerDir(digest string, storageDriver string) string {
+
+	if overlayWhiteouts(storageDriver) {
+
+		return shortLayerLink(digest)
+	}
+
+	return layerDir(digest, storageDriver)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"ttdocker/image"
+)
+
+//调用方式 ttdocker images [-q] [镜像名], 指定镜像名时只显示这个镜像名的各个 tag
+func listImages(repository string, quiet bool) error {
+
+	summaries, err := image.List()
+	if err != nil {
+
+		return err
+	}
+
+	if quiet {
+
+		//一个镜像有多个镜像名时只输出一次 ID
+		printed := map[string]bool{}
+		for _, summary := range summaries {
+
+			if (repository != "" && summary.Repository != repository) || printed[summary.ID] {
+				continue
+			}
+			printed[summary.ID] = true
+			fmt.Println(shortImageID(summary.ID))
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE\n")
+	for _, summary := range summaries {
+
+		if repository != "" && summary.Repository != repository {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			summary.Repository,
+			summary.Tag,
+			shortImageID(summary.ID),
+			createdSince(summary.Created),
+			humanSize(summary.Size))
+	}
+	if err := w.Flush(); err != nil {
+
+		log.Errorf("Flush error %v", err)
+		return err
+	}
+
+	return nil
+}
+
+/*
+	删除镜像, 和 docker rmi 一样
+	1.镜像还有其他镜像名时, 按镜像名删除只删除这个镜像名
+	2.有容器使用镜像时拒绝删除, -f 只删除镜像名, 镜像保留到容器删除之后, 容器仍然可以重新启动
+	3.按镜像 ID 删除有多个镜像名的镜像需要 -f
+*/
+func removeImage(ref string, force bool) error {
+
+	img, err := image.Find(ref)
+	if err != nil {
+
+		return err
+	}
+	names, err := image.References(img.ID)
+	if err != nil {
+
+		return err
+	}
+
+	if image.IsReference(ref) && len(names) > 1 {
+
+		if err := image.RemoveReference(ref); err != nil {
+
+			return err
+		}
+		fmt.Printf("Untagged: %s\n", ref)
+		return nil
+	}
+	if len(names) > 1 && !force {
+
+		return fmt.Errorf("image %s is referenced by multiple names %s, use -f to remove all of them", shortImageID(img.ID), strings.Join(names, ", "))
+	}
+
+	users := imageUsers(img.ID)
+	if len(users) > 0 && !force {
+
+		return fmt.Errorf("image %s is being used by container %s, remove the container first or use -f", ref, strings.Join(users, ", "))
+	}
+
+	for _, name := range names {
+
+		if err := image.RemoveReference(name); err != nil {
+
+			return err
+		}
+		fmt.Printf("Untagged: %s\n", name)
+	}
+	if len(users) > 0 {
+
+		log.Infof("image %s is kept for container %s", img.ID, strings.Join(users, ", "))
+		return nil
+	}
+
+	if err := image.Delete(img.ID); err != nil {
+
+		return err
+	}
+	fmt.Printf("Deleted: %s\n", img.ID)
+	return nil
+}
+
+//使用某个镜像的容器名, 没有记录镜像 ID 的旧容器按镜像名查找
+func imageUsers(id string) []string {
+
+	var users []string
+	for _, containerInfo := range getAllContainerInfos() {
+
+		imageID := containerInfo.ImageID
+		if imageID == "" {
+
+			if img, err := image.Find(containerInfo.Image); err == nil {
+
+				imageID = img.ID
+			}
+		}
+		if imageID == id {
+
+			users = append(users, containerInfo.Name)
+		}
+	}
+
+	return users
+}
+
+//调用方式 ttdocker tag 镜像 新镜像名, 新镜像名已经存在时指向这个镜像
+func tagImage(source string, target string) error {
+
+	img, err := image.Get(source)
+	if err != nil {
+
+		return err
+	}
+
+	return image.SetReference(target, img.ID)
+}
+
+//和 docker 一样显示 ID 的前 12 位
+func shortImageID(id string) string {
+
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+
+		return id[:12]
+	}
+
+	return id
+}
+
+//和 docker 一样使用 1000 进制的单位
+func humanSize(size int64) string {
+
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(size)
+	i := 0
+	for value >= 1000 && i < len(units) - 1 {
+
+		value /= 1000
+		i++
+	}
+
+	return fmt.Sprintf("%.3g%s", value, units[i])
+}
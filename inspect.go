@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"strings"
+	"text/template"
+	"ttdocker/cgroups/subsystems"
+	"ttdocker/container"
+	"ttdocker/network"
+)
+
+//inspect 输出的容器详细信息, 在 config.json 的基础上加上根据容器记录推算出来的路径和网络端点
+type containerInspect struct {
+	*container.ContainerInfo
+	Storage 	inspectStorage `json:"storage"`
+	Cgroup 		inspectCgroup `json:"cgroup"`
+	Networks 	[]inspectEndpoint `json:"networks"`
+	Volumes 	[]inspectVolume `json:"volumes"`
+}
+
+//容器文件系统用到的各个目录
+type inspectStorage struct {
+	Driver 			string `json:"driver"`  //创建容器时使用的存储驱动
+	RootfsPath 		string `json:"rootfsPath"`  //只读层和读写层联合挂载之后的容器根目录
+	WriteLayerPath 	string `json:"writeLayerPath"`  //容器的读写层
+	Layers 			[]string `json:"layers"`  //镜像各层解压之后的目录, 最上面的一层在前
+}
+
+//容器的 cgroup, 资源限制就是 config.json 中的 resource
+type inspectCgroup struct {
+	Path 		string `json:"path"`  //相对于 hierarchy 根节点的路径
+	Paths 		map[string]string `json:"paths"`  //每个 subsystem 中 cgroup 的绝对路径, cgroup 不存在时没有这一项
+}
+
+//容器连接的一个网络
+type inspectEndpoint struct {
+	Network 		string `json:"network"`
+	IPAddress 		string `json:"ipAddress"`
+	Gateway 		string `json:"gateway"`
+	MacAddress 		string `json:"macAddress"`
+	HostVeth 		string `json:"hostVeth"`  //veth 在宿主机一端的名字, 挂在网桥上
+	ContainerVeth 	string `json:"containerVeth"`  //veth 在容器内一端的名字
+	PortMapping 	[]string `json:"portMapping"`
+}
+
+//-v 指定的数据卷, 格式为 宿主机目录:容器内目录
+type inspectVolume struct {
+	Source 		string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+//调用方式 ttdocker inspect [--format 模板] 容器名...
+//没有 --format 时以 json 数组输出所有容器, 有 --format 时每个容器按模板输出一行
+func inspectContainers(containerNames []string, format string) error {
+
+	var tmpl *template.Template
+	if format != "" {
+
+		var err error
+		if tmpl, err = parseFormat("inspect", format); err != nil {
+
+			return err
+		}
+	}
+
+	var results []*containerInspect
+	for _, containerName := range containerNames {
+
+		containerInfo, err := getContainerInfoByName(containerName)
+		if err != nil {
+
+			return fmt.Errorf("get container %s info error %v", containerName, err)
+		}
+		results = append(results, inspectContainer(containerInfo))
+	}
+
+	if tmpl == nil {
+
+		out, err := json.MarshalIndent(results, "", "    ")
+		if err != nil {
+
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	for _, result := range results {
+
+		if err := tmpl.Execute(os.Stdout, result); err != nil {
+
+			return fmt.Errorf("execute format error %v", err)
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
+
+//解析 --format 指定的 go 模板, inspect 和 ps 共用
+func parseFormat(name string, format string) (*template.Template, error) {
+
+	tmpl, err := template.New(name).Funcs(template.FuncMap{
+		//和 docker 一样, {{json .Resource}} 以 json 输出某个字段
+		"json": func(v interface{}) (string, error) {
+
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"join": strings.Join,
+	}).Parse(format)
+	if err != nil {
+
+		return nil, fmt.Errorf("parse format %s error %v", format, err)
+	}
+
+	return tmpl, nil
+}
+
+//根据容器记录查询容器的目录, cgroup 和网络端点
+func inspectContainer(containerInfo *container.ContainerInfo) *containerInspect {
+
+	result := &containerInspect{
+		ContainerInfo: containerInfo,
+		Storage: inspectStorage{
+			Driver: containerInfo.StorageDriver,
+			RootfsPath: fmt.Sprintf(container.MntUrl, containerInfo.Name),
+			WriteLayerPath: fmt.Sprintf(container.WriteLayerUrl, containerInfo.Name),
+		},
+		Cgroup: inspectCgroup{
+			Path: containerInfo.CgroupPath,
+			Paths: map[string]string{},
+		},
+		Networks: []inspectEndpoint{},
+		Volumes: []inspectVolume{},
+	}
+	if img, err := getContainerImage(containerInfo.ImageID, containerInfo.Image); err == nil {
+
+		result.Storage.Layers = img.LayerDirs(containerInfo.StorageDriver)
+	} else {
+
+		log.Warnf("get image of container %s error %v", containerInfo.Name, err)
+	}
+
+	//容器退出后 cgroup 已经被删除, 只输出还存在的 cgroup
+	if containerInfo.CgroupPath != "" {
+
+		for _, subSysIns := range subsystems.SubsystemsIns {
+
+			if cgroupPath, err := subsystems.GetCgroupPath(subSysIns.Name(), containerInfo.CgroupPath, false); err == nil {
+
+				result.Cgroup.Paths[subSysIns.Name()] = cgroupPath
+			}
+		}
+	}
+
+	endpoints, err := network.GetEndpoints(containerInfo.Id)
+	if err != nil {
+
+		log.Warnf("get container %s endpoints error %v", containerInfo.Name, err)
+	}
+	for _, ep := range endpoints {
+
+		endpoint := inspectEndpoint{
+			IPAddress: ep.IPAddress.String(),
+			MacAddress: ep.MacAddress.String(),
+			HostVeth: ep.Device.Name,
+			ContainerVeth: ep.Device.PeerName,
+			PortMapping: ep.PortMapping,
+		}
+		if ep.Network != nil {
+
+			endpoint.Network = ep.Network.Name
+			if ep.Network.IpRange != nil {
+
+				endpoint.Gateway = ep.Network.IpRange.IP.String()
+			}
+		}
+		result.Networks = append(result.Networks, endpoint)
+	}
+
+	if containerInfo.Volume != "" {
+
+		if volumeURLs := strings.Split(containerInfo.Volume, ":"); len(volumeURLs) == 2 {
+
+			result.Volumes = append(result.Volumes, inspectVolume{Source: volumeURLs[0], Destination: volumeURLs[1]})
+		}
+	}
+
+	return result
+}
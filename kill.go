@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"ttdocker/container"
+)
+
+//kill 支持的信号名, 不区分大小写, 可以带 SIG 前缀
+var signalMap = map[string]syscall.Signal{
+
+	"ABRT": syscall.SIGABRT,
+	"ALRM": syscall.SIGALRM,
+	"BUS": syscall.SIGBUS,
+	"CHLD": syscall.SIGCHLD,
+	"CONT": syscall.SIGCONT,
+	"FPE": syscall.SIGFPE,
+	"HUP": syscall.SIGHUP,
+	"ILL": syscall.SIGILL,
+	"INT": syscall.SIGINT,
+	"IO": syscall.SIGIO,
+	"KILL": syscall.SIGKILL,
+	"PIPE": syscall.SIGPIPE,
+	"PROF": syscall.SIGPROF,
+	"PWR": syscall.SIGPWR,
+	"QUIT": syscall.SIGQUIT,
+	"SEGV": syscall.SIGSEGV,
+	"STOP": syscall.SIGSTOP,
+	"SYS": syscall.SIGSYS,
+	"TERM": syscall.SIGTERM,
+	"TRAP": syscall.SIGTRAP,
+	"TSTP": syscall.SIGTSTP,
+	"TTIN": syscall.SIGTTIN,
+	"TTOU": syscall.SIGTTOU,
+	"URG": syscall.SIGURG,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"VTALRM": syscall.SIGVTALRM,
+	"WINCH": syscall.SIGWINCH,
+	"XCPU": syscall.SIGXCPU,
+	"XFSZ": syscall.SIGXFSZ,
+}
+
+//把 9, KILL, SIGKILL, sigkill 这样的参数解析成信号
+func parseSignal(rawSignal string) (syscall.Signal, error) {
+
+	if number, err := strconv.Atoi(rawSignal); err == nil {
+
+		//实时信号的最大值是 64
+		if number <= 0 || number > 64 {
+
+			return 0, fmt.Errorf("invalid signal %s", rawSignal)
+		}
+		return syscall.Signal(number), nil
+	}
+
+	signal, ok := signalMap[strings.TrimPrefix(strings.ToUpper(rawSignal), "SIG")]
+	if !ok {
+
+		return 0, fmt.Errorf("invalid signal %s", rawSignal)
+	}
+
+	return signal, nil
+}
+
+//调用方式 ttdocker kill --signal 信号 容器名
+//向容器的 init 进程发送信号, 容器退出之后由 shim 记录退出码
+func killContainer(containerName string, rawSignal string) error {
+
+	signal, err := parseSignal(rawSignal)
+	if err != nil {
+
+		return err
+	}
+
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+
+	//被冻结的进程不会处理信号, 和 exec 一样要求先 unpause
+	if err := container.RequireStatus(containerInfo, "kill", container.RUNNING); err != nil {
+
+		return err
+	}
+
+	pid, err := strconv.Atoi(containerInfo.Pid)
+	if err != nil {
+
+		return fmt.Errorf("conver pid from string to int error %v", err)
+	}
+
+	if err := syscall.Kill(pid, signal); err != nil {
+
+		return fmt.Errorf("kill container %s with signal %d error %v", containerName, signal, err)
+	}
+
+	return nil
+}
@@ -6,52 +6,111 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"io/ioutil"
 	"os"
+	"path"
 	"strconv"
+	"strings"
 	"syscall"
 	"text/tabwriter"
+	"text/template"
+	"time"
+	"ttdocker/cgroups/subsystems"
 	"ttdocker/container"
 )
 
-func ListContainers(){
+//ps 输出的一行, --format 模板中可以使用这些字段, 例如 {{.ID}} {{.Status}}
+type psEntry struct {
+	ID 			string `json:"id"`
+	Name 		string `json:"name"`
+	Pid 		string `json:"pid"`
+	Status 		string `json:"status"`
+	Pids 		string `json:"pids"`
+	Command 	string `json:"command"`
+	Created 	string `json:"created"`  //容器创建了多久, 例如 5 minutes ago
+	CreatedAt 	string `json:"createdAt"`  //RFC3339 格式的创建时间
+	Labels 		map[string]string `json:"labels"`
+}
 
-	//　找到存储容器信息的路径 /var/run/ttdocker
-	dirURL := fmt.Sprintf(container.DefaultInfoLocation, "")
-	dirURL = dirURL[:len(dirURL) - 1]
+//调用方式 ttdocker ps [-a] [-q] [--filter key=value] [--format json|模板]
+//默认只显示运行中和暂停的容器, all 时显示所有容器
+//过滤条件中同一个 key 的多个值满足一个即可, 不同的 key 都要满足
+func ListContainers(all bool, quiet bool, filterArgs []string, format string) error {
 
-	//读取文件夹下的所有内容
-	files, err := ioutil.ReadDir(dirURL)
+	filters, err := parsePsFilters(filterArgs)
 	if err != nil {
 
-		w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-		//控制台输出的信息列
-		fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
-		if err := w.Flush(); err != nil {
+		return err
+	}
+
+	var tmpl *template.Template
+	if format != "" && format != "json" {
 
-			log.Errorf("Flush error %v", err)
-			return
+		if tmpl, err = parseFormat("ps", format); err != nil {
+
+			return err
 		}
-		return
 	}
 
-	var containers []*container.ContainerInfo
-	//遍历该文件下的所有文件
-	for _, file := range files {
-		//根据容器配置文件获取对应的信息，　然后转换成容器信息的对象
-		tmpContainer, err := getContainerInfo(file)
-		if err != nil {
+	//和 docker 一样, 按状态过滤时不需要再指定 -a
+	if _, ok := filters["status"]; ok {
 
-			log.Errorf("Get container info error %v", err)
+		all = true
+	}
+
+	var entries []*psEntry
+	for _, item := range getAllContainerInfos() {
+
+		if !all && item.Status != container.RUNNING && item.Status != container.PAUSED {
+			continue
+		}
+		if !matchPsFilters(item, filters) {
 			continue
 		}
 
-		pid, _ := strconv.Atoi(tmpContainer.Pid)
-		if !checkPid(pid) && pid != 0 {
+		entries = append(entries, &psEntry{
+			ID: item.Id,
+			Name: item.Name,
+			Pid: item.Pid,
+			Status: statusString(item),
+			Pids: getPidsCurrent(item),
+			Command: item.Command,
+			Created: createdSince(item.CreatedTime),
+			CreatedAt: item.CreatedTime,
+			Labels: item.Labels,
+		})
+	}
 
-			deleteContainerInfo(tmpContainer.Name)
-			continue
+	switch {
+
+	case quiet:
+		for _, entry := range entries {
+
+			fmt.Println(entry.ID)
 		}
+		return nil
 
-		containers = append(containers, tmpContainer)
+	case format == "json":
+		//每个容器输出一行 json, 方便脚本逐行处理
+		for _, entry := range entries {
+
+			b, err := json.Marshal(entry)
+			if err != nil {
+
+				return err
+			}
+			fmt.Println(string(b))
+		}
+		return nil
+
+	case tmpl != nil:
+		for _, entry := range entries {
+
+			if err := tmpl.Execute(os.Stdout, entry); err != nil {
+
+				return fmt.Errorf("execute format error %v", err)
+			}
+			fmt.Println()
+		}
+		return nil
 	}
 
 	//使用tabWrite.NewWrite 在控制台打印出容器信息
@@ -59,26 +118,195 @@ func ListContainers(){
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
 
 	//控制台输出的信息列
-	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
+	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tPIDS\tCOMMAND\tCREATED\n")
 
-	for _, item := range containers {
+	for _, entry := range entries {
 
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
-			item.Id,
-			item.Name,
-			item.Pid,
-			item.Status,
-			item.Command,
-			item.CreatedTime)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.ID,
+			entry.Name,
+			entry.Pid,
+			entry.Status,
+			entry.Pids,
+			entry.Command,
+			entry.Created)
 	}
 	//刷新标准输出流缓存区, 将容器里列表打印出来
 	if err := w.Flush(); err != nil {
 
 		log.Errorf("Flush error %v", err)
-		return
+		return err
 	}
+
+	return nil
 }
 
+//解析 --filter 参数, 一个参数中可以用逗号分隔多个条件, 例如 status=running,name=web*
+func parsePsFilters(filterArgs []string) (map[string][]string, error) {
+
+	filters := map[string][]string{}
+	for _, arg := range filterArgs {
+
+		for _, filter := range strings.Split(arg, ",") {
+
+			kv := strings.SplitN(filter, "=", 2)
+			if len(kv) != 2 || kv[1] == "" {
+
+				return nil, fmt.Errorf("bad format of filter %s, expected key=value", filter)
+			}
+
+			switch kv[0] {
+
+			case "name":
+				//提前检查通配符的格式, 避免每个容器都匹配失败
+				if _, err := path.Match(kv[1], ""); err != nil {
+
+					return nil, fmt.Errorf("invalid name filter %s: %v", kv[1], err)
+				}
+			case "status", "label":
+			default:
+				return nil, fmt.Errorf("invalid filter %s, supported filters are status, name and label", kv[0])
+			}
+			filters[kv[0]] = append(filters[kv[0]], kv[1])
+		}
+	}
+
+	return filters, nil
+}
+
+func matchPsFilters(containerInfo *container.ContainerInfo, filters map[string][]string) bool {
+
+	for key, values := range filters {
+
+		matched := false
+		for _, value := range values {
+
+			switch key {
+
+			case "status":
+				matched = containerInfo.Status == value
+			case "name":
+				//name 支持 web* 这样的通配符
+				matched, _ = path.Match(value, containerInfo.Name)
+			case "label":
+				//label=key 只要求有这个标签, label=key=value 要求标签的值也相同
+				kv := strings.SplitN(value, "=", 2)
+				labelValue, ok := containerInfo.Labels[kv[0]]
+				matched = ok && (len(kv) == 1 || labelValue == kv[1])
+			}
+			if matched {
+				break
+			}
+		}
+		if !matched {
+
+			return false
+		}
+	}
+
+	return true
+}
+
+//把 RFC3339 格式的创建时间转换成 5 minutes ago 这样的相对时间, 旧版本记录的时间无法解析时原样显示
+func createdSince(createdTime string) string {
+
+	created, err := time.Parse(time.RFC3339, createdTime)
+	if err != nil {
+
+		return createdTime
+	}
+
+	return humanDuration(time.Since(created)) + " ago"
+}
+
+//和 docker 一样把时间间隔转换成容易阅读的字符串
+func humanDuration(d time.Duration) string {
+
+	if seconds := int(d.Seconds()); seconds < 1 {
+
+		return "Less than a second"
+	} else if seconds == 1 {
+
+		return "1 second"
+	} else if seconds < 60 {
+
+		return fmt.Sprintf("%d seconds", seconds)
+	} else if minutes := int(d.Minutes()); minutes == 1 {
+
+		return "About a minute"
+	} else if minutes < 60 {
+
+		return fmt.Sprintf("%d minutes", minutes)
+	} else if hours := int(d.Hours() + 0.5); hours == 1 {
+
+		return "About an hour"
+	} else if hours < 48 {
+
+		return fmt.Sprintf("%d hours", hours)
+	} else if hours < 24*7*2 {
+
+		return fmt.Sprintf("%d days", hours/24)
+	} else if hours < 24*30*2 {
+
+		return fmt.Sprintf("%d weeks", hours/24/7)
+	} else if hours < 24*365*2 {
+
+		return fmt.Sprintf("%d months", hours/24/30)
+	}
+
+	return fmt.Sprintf("%d years", int(d.Hours())/24/365)
+}
+
+
+//读取所有容器的信息, 进程已经不存在的容器会被标记为退出
+func getAllContainerInfos() []*container.ContainerInfo {
+
+	//　找到存储容器信息的路径 /var/run/ttdocker
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, "")
+	dirURL = dirURL[:len(dirURL) - 1]
+
+	//读取文件夹下的所有内容
+	files, err := ioutil.ReadDir(dirURL)
+	if err != nil {
+
+		return nil
+	}
+
+	var containers []*container.ContainerInfo
+	//遍历该文件下的所有文件
+	for _, file := range files {
+
+		//network 等目录下没有 config.json, 不是容器
+		configFile := fmt.Sprintf(container.DefaultInfoLocation, file.Name()) + container.ConfigName
+		if _, err := os.Stat(configFile); err != nil {
+			continue
+		}
+
+		//根据容器配置文件获取对应的信息，　然后转换成容器信息的对象
+		tmpContainer, err := getContainerInfo(file)
+		if err != nil {
+
+			log.Errorf("Get container info error %v", err)
+			continue
+		}
+
+		//容器进程已经不存在, 但是 shim 还没有记录退出(例如 shim 被杀掉了), 只在显示时当作退出
+		//ps 是只读的操作, 不修改 config.json, 也不释放 cgroup, 这些由 shim 记录退出时完成
+		pid, _ := strconv.Atoi(tmpContainer.Pid)
+		if pid != 0 && !checkPid(pid) && container.HasStatus(tmpContainer, container.CREATED, container.RUNNING, container.PAUSED) {
+
+			tmpContainer.Status = container.Exit
+			if tmpContainer.ManuallyStopped {
+
+				tmpContainer.Status = container.STOP
+			}
+		}
+
+		containers = append(containers, tmpContainer)
+	}
+
+	return containers
+}
 
 func getContainerInfo(file os.FileInfo) (* container.ContainerInfo, error) {
 
@@ -107,6 +335,76 @@ func getContainerInfo(file os.FileInfo) (* container.ContainerInfo, error) {
 	return &containerInfo, nil
 }
 
+//容器进程退出后, 从 cgroup 中检查是否因为 OOM 被杀掉, 然后更新容器状态并释放 cgroup
+func markContainerExited(containerInfo *container.ContainerInfo) {
+
+	if containerInfo.CgroupPath != "" {
+
+		memory := &subsystems.MemorySubSystem{}
+		oomKilled, err := memory.OOMKilled(containerInfo.CgroupPath)
+		if err != nil {
+
+			log.Debugf("get container %s oom status error %v", containerInfo.Name, err)
+		}
+		containerInfo.OOMKilled = oomKilled
+	}
+
+	//容器已经退出, 释放它的 cgroup
+	if err := destroyContainerCgroup(containerInfo); err != nil {
+
+		log.Errorf("destroy container %s cgroup error %v", containerInfo.Name, err)
+	}
+
+	//被 stop 停止的容器保持 stopped 状态
+	if containerInfo.Status == container.STOP || containerInfo.ManuallyStopped {
+
+		containerInfo.Status = container.STOP
+	} else {
+
+		containerInfo.Status = container.Exit
+	}
+	if containerInfo.FinishedTime == "" {
+
+		containerInfo.FinishedTime = time.Now().Format(time.RFC3339)
+	}
+	containerInfo.Pid = " "
+
+	if err := writeContainerInfo(containerInfo); err != nil {
+
+		log.Errorf("update container %s info error %v", containerInfo.Name, err)
+	}
+}
+
+//ps 中显示的状态, 被 OOM 杀掉的容器显示为 exited (OOMKilled)
+func statusString(containerInfo *container.ContainerInfo) string {
+
+	if containerInfo.Status == container.Exit && containerInfo.OOMKilled {
+
+		return containerInfo.Status + " (OOMKilled)"
+	}
+
+	return containerInfo.Status
+}
+
+//从容器 cgroup 的 pids.current 中读取容器内当前的进程数, 读不到时显示 -
+func getPidsCurrent(containerInfo *container.ContainerInfo) string {
+
+	if (containerInfo.Status != container.RUNNING && containerInfo.Status != container.PAUSED) || containerInfo.CgroupPath == "" {
+
+		return "-"
+	}
+
+	pids := &subsystems.PidsSubSystem{}
+	current, err := pids.Current(containerInfo.CgroupPath)
+	if err != nil {
+
+		log.Debugf("get container %s pids error %v", containerInfo.Name, err)
+		return "-"
+	}
+
+	return strconv.Itoa(current)
+}
+
 func checkPid(pid int) bool {
 	process, err := os.FindProcess(pid)
 	if err != nil {
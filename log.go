@@ -10,6 +10,13 @@ import (
 
 func logContainer(containerName string ){
 
+	//容器的任何状态都可以查看日志, 这里只检查容器是否存在
+	if _, err := getContainerInfoByName(containerName); err != nil {
+
+		log.Errorf("get container %s info error %v", containerName, err)
+		return
+	}
+
 	//找到对应的文件夹的位置
 	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
 	logFileLocation := dirURL + container.ContainerLogFile
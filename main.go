@@ -18,16 +18,39 @@ func main(){
 
 	app.Commands = []cli.Command{
 		initCommand,
+		shimCommand,
 		runCommand,
+		createCommand,
+		startCommand,
+		restartCommand,
 		commitCommand,					//把运行状态容器的内存存储成镜像保存下来
+		loadCommand,
+		saveCommand,
+		imagesCommand,
+		removeImageCommand,
+		tagCommand,
 		listCommand,
+		inspectCommand,
 		logCommand,
 		execCommand,
 		stopCommand,
+		killCommand,
 		removeCommand,
+		updateCommand,
+		statsCommand,
+		pauseCommand,
+		unpauseCommand,
 		networkCommand,
 	}
 
+	app.Flags = []cli.Flag{
+
+		cli.StringFlag{
+			Name: "storage-driver",
+			Usage: "storage driver to use for new containers, overlay2|aufs, detected from /proc/filesystems by default",
+		},
+	}
+
 	//初始化 日志配置
 	//在app run 执行之前执行的
 	app.Before = func(context *cli.Context) error {
@@ -5,114 +5,304 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/urfave/cli"
 	"os"
+	"time"
 	"ttdocker/cgroups/subsystems"
 	"ttdocker/container"
+	"ttdocker/image"
 	"ttdocker/network"
 )
 
 //定义了runCommand 的Flags， 其作用类似于命令运行时使用 -- 来指定参数
+var runFlags = []cli.Flag{
+
+	cli.BoolFlag{
+		Name: "ti",				// Name: "port, p"  --port 等价于 -p
+		Usage: "enable tty",
+	},
+	cli.StringFlag{
+		Name: "m",
+		Usage: "memory limit",
+	},
+	cli.StringFlag{
+		Name: "memory-swap",
+		Usage: "total memory plus swap limit, -1 means unlimited swap",
+	},
+	cli.StringFlag{
+		Name: "memory-reservation",
+		Usage: "memory soft limit",
+	},
+	cli.BoolFlag{
+		Name: "oom-kill-disable",
+		Usage: "disable oom killer",
+	},
+	cli.StringFlag{
+		Name: "cpushare",
+		Usage: "cpushare limit",
+	},
+
+	cli.StringFlag{
+		Name: "cpus",
+		Usage: "number of cpus, e.g. 1.5",
+	},
+	cli.StringFlag{
+		Name: "cpu-quota",
+		Usage: "cpu cfs quota in microseconds",
+	},
+	cli.StringFlag{
+		Name: "cpu-period",
+		Usage: "cpu cfs period in microseconds",
+	},
+	cli.StringFlag{
+		Name: "cpuset",
+		Usage: "cpuset limit",
+	},
+	cli.StringFlag{
+		Name: "v",
+		Usage: "volume",
+	},
+	cli.BoolFlag{
+		Name: "d",
+		Usage: "detach container",
+	},
+	//　提供 run 后面的 -name 指定容器名字参数
+	cli.StringFlag{
+		Name: "name",
+		Usage: "container name",
+	},
+	cli.StringSliceFlag{
+		Name: "e",
+		Usage: "set enviornment",
+	},
+	cli.StringFlag{
+		Name: "net",
+		Usage: "container network",
+	},
+	cli.StringSliceFlag{
+		Name: "p",
+		Usage: "port mapping",
+	},
+	cli.StringFlag{
+		Name: "pids-limit",
+		Usage: "pids limit",
+	},
+	cli.StringFlag{
+		Name: "blkio-weight",
+		Usage: "block io weight, between 10 and 1000",
+	},
+	cli.StringSliceFlag{
+		Name: "device-read-bps",
+		Usage: "limit read rate (bytes per second) from a device, e.g. /dev/sda:1048576",
+	},
+	cli.StringSliceFlag{
+		Name: "device-write-bps",
+		Usage: "limit write rate (bytes per second) to a device",
+	},
+	cli.StringSliceFlag{
+		Name: "device-read-iops",
+		Usage: "limit read rate (io per second) from a device",
+	},
+	cli.StringSliceFlag{
+		Name: "device-write-iops",
+		Usage: "limit write rate (io per second) to a device",
+	},
+	cli.StringFlag{
+		Name: "cgroup-parent",
+		Usage: "parent cgroup for the container, e.g. ttdocker.slice",
+	},
+	cli.BoolFlag{
+		Name: "rm",
+		Usage: "automatically remove the container when it exits",
+	},
+	cli.BoolFlag{
+		Name: "init",
+		Usage: "run an init inside the container that forwards signals and reaps processes",
+	},
+	cli.StringFlag{
+		Name: "restart",
+		Usage: "restart policy to apply when a container exits, no|on-failure[:max-retries]|always|unless-stopped",
+	},
+	cli.StringFlag{
+		Name: "u",
+		Usage: "username or uid, format: <name|uid>[:<group|gid>], default is the image user",
+	},
+	cli.StringFlag{
+		Name: "w",
+		Usage: "working directory inside the container, must be an absolute path, default is the image working directory",
+	},
+	cli.StringFlag{
+		Name: "entrypoint",
+		Usage: "overwrite the default entrypoint of the image, empty string resets it",
+	},
+	cli.StringFlag{
+		Name: "hostname",
+		Usage: "container host name, default is the container id",
+	},
+	cli.StringSliceFlag{
+		Name: "label",
+		Usage: "set metadata on a container, format: key[=value]",
+	},
+}
+
 var runCommand = cli.Command{
 
 	Name: "run",
-	Usage: `Create a container with namespace and cgroup limit ttdocker run -ti [command]`,
+	Usage: `Create a container with namespace and cgroup limit ttdocker run -ti [image] [command]`,
+	Flags: runFlags,
+
+	/*
+		这里是 run 命令执行的真正函数
+		1.判断参数是否包含 command
+		2.获取用户指定的command
+		3.调用 Run function 去准备启动容器
+	*/
+	Action: func(context *cli.Context) error {
+
+		spec, err := parseRunSpec(context)
+		if err != nil {
+
+			return err
+		}
+
+		Run(spec)
+
+		return nil
+	},
+}
+
+//create 和 run 使用相同的参数, 创建好的容器停在 init 管道上, 等待 start 命令
+var createCommand = cli.Command{
+
+	Name: "create",
+	Usage: `Create a container but do not start it ttdocker create [image] [command]`,
+	Flags: runFlags,
+	Action: func(context *cli.Context) error {
+
+		if context.Bool("ti") {
+
+			return fmt.Errorf("ti paramter can not be used with create")
+		}
+
+		spec, err := parseRunSpec(context)
+		if err != nil {
+
+			return err
+		}
+
+		return createContainer(spec)
+	},
+}
+
+var startCommand = cli.Command{
+
+	Name: "start",
+	Usage: "start a created or stopped container",
+	Action: func(context *cli.Context) error {
+
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+
+		return startContainer(context.Args().Get(0))
+	},
+}
+
+var restartCommand = cli.Command{
+
+	Name: "restart",
+	Usage: "restart a container ttdocker restart -t 10 [container]",
 	Flags: []cli.Flag{
 
-		cli.BoolFlag{
-			Name: "ti",				// Name: "port, p"  --port 等价于 -p
-			Usage: "enable tty",
+		cli.IntFlag{
+			Name: "t",
+			Value: 10,
+			Usage: "seconds to wait for stop before killing it",
 		},
+	},
+	Action: func(context *cli.Context) error {
+
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+
+		if context.Int("t") < 0 {
+			return fmt.Errorf("invalid stop timeout %d", context.Int("t"))
+		}
+
+		return restartContainer(context.Args().Get(0), time.Duration(context.Int("t")) * time.Second)
+	},
+}
+
+var updateCommand = cli.Command{
+
+	Name: "update",
+	Usage: "update resource limits of a container ttdocker update --m 1g --cpushare 512 [container]",
+	Flags: []cli.Flag{
+
 		cli.StringFlag{
 			Name: "m",
 			Usage: "memory limit",
 		},
+		cli.StringFlag{
+			Name: "memory-swap",
+			Usage: "total memory plus swap limit, -1 means unlimited swap",
+		},
+		cli.StringFlag{
+			Name: "memory-reservation",
+			Usage: "memory soft limit",
+		},
 		cli.StringFlag{
 			Name: "cpushare",
 			Usage: "cpushare limit",
 		},
-
 		cli.StringFlag{
-			Name: "cputest",
-			Usage: "cpuset limit",
+			Name: "cpus",
+			Usage: "number of cpus, e.g. 1.5",
 		},
 		cli.StringFlag{
-			Name: "v",
-			Usage: "volume",
+			Name: "cpu-quota",
+			Usage: "cpu cfs quota in microseconds",
 		},
-		cli.BoolFlag{
-			Name: "d",
-			Usage: "detach container",
-		},
-		//　提供 run 后面的 -name 指定容器名字参数
 		cli.StringFlag{
-			Name: "name",
-			Usage: "container name",
+			Name: "cpu-period",
+			Usage: "cpu cfs period in microseconds",
 		},
-		cli.StringSliceFlag{
-			Name: "e",
-			Usage: "set enviornment",
+		cli.StringFlag{
+			Name: "cpuset",
+			Usage: "cpuset limit",
 		},
 		cli.StringFlag{
-			Name: "net",
-			Usage: "container network",
+			Name: "pids-limit",
+			Usage: "pids limit",
 		},
-		cli.StringSliceFlag{
-			Name: "p",
-			Usage: "port mapping",
+		cli.StringFlag{
+			Name: "blkio-weight",
+			Usage: "block io weight, between 10 and 1000",
 		},
 	},
-
-	/*
-		这里是 run 命令执行的真正函数
-		1.判断参数是否包含 command
-		2.获取用户指定的command
-		3.调用 Run function 去准备启动容器
-	*/
 	Action: func(context *cli.Context) error {
 
 		if len(context.Args()) < 1 {
 
-			fmt.Println(context.Args())
-			return fmt.Errorf("missing container command")
-		}
-
-		var cmdArray []string
-
-		for _, arg := range context.Args(){
-
-			cmdArray = append(cmdArray, arg)
-
-		}
-
-		createTty := context.Bool("ti")
-		detach := context.Bool("d")
-		volume := context.String("v")
-		network := context.String("net")
-
-		envSlice := context.StringSlice("e")
-		portmapping := context.StringSlice("p")
-
-
-		if createTty && detach {
-
-			return fmt.Errorf("ti and d paramter can not both provided")
+			return fmt.Errorf("missing container name")
 		}
 
-		resConf := &subsystems.ResourceConfig{
+		//只包含本次指定的参数, 没有指定的保持原来的限制
+		update := &subsystems.ResourceConfig{
 
-			//取出各个字段对应的参数值
 			MemoryLimit: context.String("m"),
-			CpuSet: context.String("cpuset"),
+			MemorySwap: context.String("memory-swap"),
+			MemoryReservation: context.String("memory-reservation"),
 			CpuShare: context.String("cpushare"),
+			Cpus: context.String("cpus"),
+			CpuQuota: context.String("cpu-quota"),
+			CpuPeriod: context.String("cpu-period"),
+			CpuSet: context.String("cpuset"),
+			PidsLimit: context.String("pids-limit"),
+			BlkioWeight: context.String("blkio-weight"),
 		}
 
-		containerName := context.String("name")
-
-		imageName := cmdArray[0]
-		cmdArray = cmdArray[1:]
-
-		Run(createTty, cmdArray,resConf, volume, containerName, imageName, envSlice, network, portmapping)
-
-		return nil
+		containerName := context.Args().Get(0)
+		return updateContainer(containerName, update)
 	},
 }
 
@@ -120,16 +310,42 @@ var initCommand = cli.Command{
 
 	Name: "init",
 	Usage: "init container process run user's process in container. ",
+	Flags: []cli.Flag{
+
+		cli.BoolFlag{
+			Name: "tiny-init",
+			Usage: "stay as pid 1, forward signals and reap zombies",
+		},
+	},
 	Action: func(context *cli.Context) error {
 
-		err := container.RunContainerInitProcess()
+		err := container.RunContainerInitProcess(context.Bool("tiny-init"))
 		return err
 	},
 }
 
+//后台运行的容器由 shim 进程看管, 只在 run 内部调用
+var shimCommand = cli.Command{
+
+	Name: "shim",
+	Usage: "supervise a detached container, wait for it and record its exit code",
+	Hidden: true,
+	Action: func(context *cli.Context) error {
+
+		return runShim()
+	},
+}
+
 var commitCommand = cli.Command{
 	Name: "commit",
 	Usage: "commit a container into image",
+	Flags: []cli.Flag{
+
+		cli.StringSliceFlag{
+			Name: "change, c",
+			Usage: "apply Dockerfile instruction to the image config, e.g. 'CMD [\"sh\"]', supported: CMD, ENTRYPOINT, ENV, WORKDIR, USER, EXPOSE",
+		},
+	},
 	Action: func(context *cli.Context) error {
 
 		if len(context.Args()) < 2 {
@@ -139,19 +355,170 @@ var commitCommand = cli.Command{
 		containerName := context.Args().Get(0)
 		imageName := context.Args().Get(1)
 
-		commitContainer(containerName, imageName)
+		return commitContainer(containerName, imageName, context.StringSlice("change"))
+	},
+}
+
+var loadCommand = cli.Command{
+	Name: "load",
+	Usage: "load images from an OCI image layout or docker save archive, ttdocker load -i image.tar",
+	Flags: []cli.Flag{
+
+		cli.StringFlag{
+			Name: "i",
+			Usage: "read from the tar archive file, gzip compressed archives are also accepted",
+		},
+	},
+	Action: func(context *cli.Context) error {
+
+		archive := context.String("i")
+		if archive == "" {
+
+			return fmt.Errorf("missing input archive, use -i image.tar")
+		}
+
+		return image.Load(archive)
+	},
+}
+
+var saveCommand = cli.Command{
+	Name: "save",
+	Usage: "save images to a tar archive in OCI image layout, ttdocker save -o image.tar [image...]",
+	Flags: []cli.Flag{
+
+		cli.StringFlag{
+			Name: "o",
+			Usage: "write to the tar archive file",
+		},
+	},
+	Action: func(context *cli.Context) error {
+
+		output := context.String("o")
+		if output == "" {
+
+			return fmt.Errorf("missing output file, use -o image.tar")
+		}
+		if len(context.Args()) < 1 {
+
+			return fmt.Errorf("missing image name")
+		}
+
+		return image.Save(context.Args(), output)
+	},
+}
+
+var imagesCommand = cli.Command{
+	Name: "images",
+	Usage: "list images, ttdocker images [repository]",
+	Flags: []cli.Flag{
+
+		cli.BoolFlag{
+			Name: "q",
+			Usage: "only display image ids",
+		},
+	},
+	Action: func(context *cli.Context) error {
+
+		return listImages(context.Args().First(), context.Bool("q"))
+	},
+}
+
+var removeImageCommand = cli.Command{
+	Name: "rmi",
+	Usage: "remove images, ttdocker rmi [image...]",
+	Flags: []cli.Flag{
+
+		cli.BoolFlag{
+			Name: "f",
+			Usage: "remove all names of the image, images used by containers are kept until the containers are removed",
+		},
+	},
+	Action: func(context *cli.Context) error {
+
+		if len(context.Args()) < 1 {
+
+			return fmt.Errorf("missing image name")
+		}
+
+		//一个镜像删除失败时继续删除其他镜像
+		failed := false
+		for _, ref := range context.Args() {
+
+			if err := removeImage(ref, context.Bool("f")); err != nil {
+
+				log.Errorf("remove image %s error %v", ref, err)
+				failed = true
+			}
+		}
+		if failed {
+
+			return fmt.Errorf("failed to remove some images")
+		}
 
 		return nil
 	},
 }
 
+var tagCommand = cli.Command{
+	Name: "tag",
+	Usage: "create a name that refers to an image, ttdocker tag [image] [name[:tag]]",
+	Action: func(context *cli.Context) error {
+
+		if len(context.Args()) < 2 {
+
+			return fmt.Errorf("missing image name, ttdocker tag [image] [name[:tag]]")
+		}
+
+		return tagImage(context.Args().Get(0), context.Args().Get(1))
+	},
+}
+
 var listCommand = cli.Command{
 	Name: "ps",
-	Usage: "list all the containers",
+	Usage: "list containers, only running containers are shown by default",
+	Flags: []cli.Flag{
+
+		cli.BoolFlag{
+			Name: "a",
+			Usage: "show all containers, including created, stopped and exited containers",
+		},
+		cli.BoolFlag{
+			Name: "q",
+			Usage: "only display container IDs",
+		},
+		cli.StringSliceFlag{
+			Name: "filter",
+			Usage: "filter output based on conditions, e.g. status=running,name=web*,label=k=v",
+		},
+		cli.StringFlag{
+			Name: "format",
+			Usage: "json or a go template, e.g. {{.ID}} {{.Name}} {{.Status}}",
+		},
+	},
 	Action: func(context *cli.Context) error{
 
-		ListContainers()
-		return nil
+		return ListContainers(context.Bool("a"), context.Bool("q"), context.StringSlice("filter"), context.String("format"))
+	},
+}
+
+var inspectCommand = cli.Command{
+
+	Name: "inspect",
+	Usage: "display detailed information on containers ttdocker inspect [container...]",
+	Flags: []cli.Flag{
+
+		cli.StringFlag{
+			Name: "format, f",
+			Usage: "format the output using the given go template, e.g. {{.Status}} {{json .Resource}}",
+		},
+	},
+	Action: func(context *cli.Context) error {
+
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("please input your container name")
+		}
+
+		return inspectContainers(context.Args(), context.String("format"))
 	},
 }
 
@@ -176,11 +543,22 @@ var execCommand = cli.Command{
 
 	Name: "exec",
 	Usage: "exec a command into container",
+	Flags: []cli.Flag{
+
+		cli.StringFlag{
+			Name: "u",
+			Usage: "username or uid, format: <name|uid>[:<group|gid>], default is the user of run",
+		},
+		cli.StringFlag{
+			Name: "w",
+			Usage: "working directory inside the container, default is the working directory of run",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		//This is for callback
 		if os.Getenv(ENV_EXEC_PID) != "" {
 
-			log.Infof("pid callback pid %s", os.Getgid())
+			log.Infof("pid callback pid %d", os.Getpid())
 			return nil
 		}
 
@@ -198,7 +576,7 @@ var execCommand = cli.Command{
 		}
 
 		//执行命令
-		ExecContainer(containerName, commandArray)
+		ExecContainer(containerName, commandArray, context.String("u"), context.String("w"))
 
 		return nil
 	},
@@ -207,17 +585,49 @@ var execCommand = cli.Command{
 var stopCommand = cli.Command{
 
 	Name: "stop",
-	Usage: "stop a container",
+	Usage: "stop a container ttdocker stop -t 10 [container]",
+	Flags: []cli.Flag{
+
+		cli.IntFlag{
+			Name: "t",
+			Value: 10,
+			Usage: "seconds to wait for stop before killing it",
+		},
+	},
 	Action: func(context *cli.Context) error {
 
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("Miss container name")
 		}
 
+		if context.Int("t") < 0 {
+			return fmt.Errorf("invalid stop timeout %d", context.Int("t"))
+		}
+
 		containerName := context.Args().Get(0)
-		stopContainer(containerName)
+		return stopContainer(containerName, time.Duration(context.Int("t")) * time.Second)
+	},
+}
 
-		return nil
+var killCommand = cli.Command{
+
+	Name: "kill",
+	Usage: "send a signal to a running container ttdocker kill --signal SIGHUP [container]",
+	Flags: []cli.Flag{
+
+		cli.StringFlag{
+			Name: "signal, s",
+			Value: "KILL",
+			Usage: "signal to send, name or number",
+		},
+	},
+	Action: func(context *cli.Context) error {
+
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+
+		return killContainer(context.Args().Get(0), context.String("signal"))
 	},
 }
 
@@ -232,9 +642,56 @@ var removeCommand = cli.Command{
 		}
 
 		containerName := context.Args().Get(0)
-		removeContainer(containerName)
+		return removeContainer(containerName)
+	},
+}
 
-		return nil
+var pauseCommand = cli.Command{
+
+	Name: "pause",
+	Usage: "pause all processes within a container",
+	Action: func(context *cli.Context) error {
+
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+
+		return pauseContainer(context.Args().Get(0))
+	},
+}
+
+var unpauseCommand = cli.Command{
+
+	Name: "unpause",
+	Usage: "unpause all processes within a container",
+	Action: func(context *cli.Context) error {
+
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+
+		return unpauseContainer(context.Args().Get(0))
+	},
+}
+
+var statsCommand = cli.Command{
+
+	Name: "stats",
+	Usage: "display a live stream of container resource usage ttdocker stats [container...]",
+	Flags: []cli.Flag{
+
+		cli.BoolFlag{
+			Name: "no-stream",
+			Usage: "print the first result only",
+		},
+		cli.StringFlag{
+			Name: "format",
+			Usage: "output format, only json is supported",
+		},
+	},
+	Action: func(context *cli.Context) error {
+
+		return statsContainers(context.Args(), context.Bool("no-stream"), context.String("format"))
 	},
 }
 
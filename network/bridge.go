@@ -115,9 +115,17 @@ func (d *BridgeNetworkDriver) Connect (network *Network, endpoint *Endpoint) err
 	return nil
 }
 
+//删除宿主机一端的 veth, 另一端会被一起删除
+//容器的网络 namespace 销毁时 veth 已经被内核删除了, 这时什么也不做
 func (d *BridgeNetworkDriver) Disconnect(network Network, endpoint *Endpoint) error {
 
-	return nil
+	link, err := netlink.LinkByName(endpoint.Device.Name)
+	if err != nil {
+
+		return nil
+	}
+
+	return netlink.LinkDel(link)
 }
 
 //初始化Bridge 设备
@@ -193,7 +201,7 @@ func createBridgeInterface(bridgeName string) error {
 	la := netlink.NewLinkAttrs()
 	la.Name = bridgeName
 	//使用刚才创建的 Link 的属性创建 netlink 的Bridge 对象
-	br := &netlink.Bridge{la}
+	br := &netlink.Bridge{LinkAttrs: la}
 	//调用netlink的Linkadd方法, 创建 Bridge虚拟网络设备
 	// netlink 的Linkadd 方法是用来创建虚拟网络设备的 相当于 ip link add xxxx
 	if err := netlink.LinkAdd(br); err != nil {
@@ -269,7 +277,7 @@ func setInterfaceIP(name string, rawIP string) error {
 		还回配置路由表 192.168.0.0/24 转发到这个  testbridge 的网络接口上面
 		通过调用 netlink 的AddrAdd方法,配置Linux Bridge 的地址和路由表
 	*/
-	addr := &netlink.Addr{ ipNet, "", 0, 0, nil}
+	addr := &netlink.Addr{IPNet: ipNet}
 
 	//等价于 ip addr 192.xxx.xxx.xxx/24 dev testbridge
 	return netlink.AddrAdd(iface, addr)
@@ -200,7 +200,9 @@ func (ipam *IPAM) Release(subnet *net.IPNet, ipaddr *net.IP) error {
 	}
 
 	c := 0
-	releaseIP := ipaddr.To4() 									//To4将一个IPv4地址转换为4字节表示
+	//复制一份, 不修改调用者传进来的 IP
+	releaseIP := make(net.IP, net.IPv4len)
+	copy(releaseIP, ipaddr.To4()) 									//To4将一个IPv4地址转换为4字节表示
 	releaseIP[3] -= 1
 
 	for t := uint(4); t > 0; t -= 1 {
@@ -209,6 +211,10 @@ func (ipam *IPAM) Release(subnet *net.IPNet, ipaddr *net.IP) error {
 	}
 
 	ipalloc := []byte((*ipam.Subnets)[subnet.String()])
+	if c < 0 || c >= len(ipalloc) {
+
+		return fmt.Errorf("ip %s is not allocated from subnet %s", ipaddr.String(), subnet.String())
+	}
 	ipalloc[c] = '0'
 
 	(*ipam.Subnets)[subnet.String()] = string(ipalloc)
@@ -6,12 +6,14 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
+	"io/ioutil"
 	"net"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 	"ttdocker/container"
@@ -19,6 +21,7 @@ import (
 
 var (
 	defaultNetworkPath = "/var/run/ttdocker/network/network/"
+	defaultEndpointPath = "/var/run/ttdocker/network/endpoint/"
 	drivers 		   = map[string]NetworkDriver{}
 	networks  		   = map[string]*Network{}
 )
@@ -79,7 +82,7 @@ func (nw *Network) dump (dumpPath string) error {
 	nwFile, err := os.OpenFile(nwPath, os.O_TRUNC | os.O_WRONLY | os.O_CREATE, 0644)
 	if err != nil {
 
-		logrus.Errorf("error :", err)
+		logrus.Errorf("error : %v", err)
 		return err
 	}
 	defer nwFile.Close()
@@ -88,7 +91,7 @@ func (nw *Network) dump (dumpPath string) error {
 	nwJson, err := json.Marshal(nw)
 	if err != nil {
 
-		logrus.Errorf("error: ", err)
+		logrus.Errorf("error: %v", err)
 		return err
 	}
 
@@ -96,7 +99,7 @@ func (nw *Network) dump (dumpPath string) error {
 	_, err = nwFile.Write(nwJson)
 	if err != nil {
 
-		logrus.Errorf("error: ", err)
+		logrus.Errorf("error: %v", err)
 		return err
 	}
 
@@ -124,7 +127,7 @@ func (nw *Network) load(dumpPath string) error {
 	err = json.Unmarshal(nwJson[:n], nw)
 	if err != nil {
 
-		logrus.Errorf("Error load nw info", err)
+		logrus.Errorf("Error load nw info %v", err)
 		return err
 	}
 
@@ -149,6 +152,97 @@ func (nw *Network) remove(dumpPath string) error {
 }
 
 
+//将网络端点的信息保存在文件系统中, 文件名为端点的ID, 即 <容器ID>-<网络名>
+//以便 stats, inspect 等命令查询容器的 veth 设备和 IP 地址
+func (ep *Endpoint) dump(dumpPath string) error {
+
+	if err := os.MkdirAll(dumpPath, 0644); err != nil {
+
+		return err
+	}
+
+	epJson, err := json.Marshal(ep)
+	if err != nil {
+
+		return err
+	}
+
+	return ioutil.WriteFile(path.Join(dumpPath, ep.ID), epJson, 0644)
+}
+
+func (ep *Endpoint) load(dumpPath string) error {
+
+	epJson, err := ioutil.ReadFile(dumpPath)
+	if err != nil {
+
+		return err
+	}
+
+	return json.Unmarshal(epJson, ep)
+}
+
+//获取容器连接的所有网络端点
+func GetEndpoints(containerID string) ([]*Endpoint, error) {
+
+	files, err := ioutil.ReadDir(defaultEndpointPath)
+	if err != nil {
+
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var endpoints []*Endpoint
+	for _, file := range files {
+
+		if !strings.HasPrefix(file.Name(), containerID + "-") {
+			continue
+		}
+
+		ep := &Endpoint{}
+		if err := ep.load(path.Join(defaultEndpointPath, file.Name())); err != nil {
+
+			logrus.Errorf("error load endpoint %s: %v", file.Name(), err)
+			continue
+		}
+		endpoints = append(endpoints, ep)
+	}
+
+	return endpoints, nil
+}
+
+//读取网络端点在宿主机一端 veth 设备上的收发字节数
+//宿主机一端收到的数据就是容器发出的数据, 所以这里返回的是站在容器角度的收发字节数
+func EndpointStats(ep *Endpoint) (rxBytes uint64, txBytes uint64, err error) {
+
+	statsDir := path.Join("/sys/class/net", ep.Device.Name, "statistics")
+
+	hostTx, err := readCounter(path.Join(statsDir, "tx_bytes"))
+	if err != nil {
+
+		return 0, 0, err
+	}
+	hostRx, err := readCounter(path.Join(statsDir, "rx_bytes"))
+	if err != nil {
+
+		return 0, 0, err
+	}
+
+	return hostTx, hostRx, nil
+}
+
+func readCounter(file string) (uint64, error) {
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+
+		return 0, err
+	}
+
+	return strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+}
+
 func Init() error{
 	//加载网络驱动
 	var bridgeDriver = BridgeNetworkDriver{}
@@ -375,6 +469,8 @@ func configEndpointIpAddressAndRoute(ep *Endpoint, cinfo *container.ContainerInf
 
 		return fmt.Errorf("fail config endpoint: %v", err)
 	}
+	//记录容器内 veth 端点的 MAC 地址, 供 inspect 查询
+	ep.MacAddress = peerLink.Attrs().HardwareAddr
 	/*
 		将容器的网络端点加入到容器的网络空间中
 		并使这个函数下面的操作都在这个网络空间中进行
@@ -506,10 +602,68 @@ func Connect(networkName string, cinfo *container.ContainerInfo) error {
 
 	//配置容器到宿主机的端口映射
 	//配置端口映射信息, 例如 ttdocker run -p 8080:80
-	return configPortMapping(ep, cinfo)
+	if err = configPortMapping(ep, cinfo); err != nil {
+		return err
+	}
+
+	//保存网络端点的信息
+	return ep.dump(defaultEndpointPath)
 }
 
+//断开容器和网络的连接, 删除 veth 设备和端口映射, 释放容器的 IP 地址
+//容器退出后重复调用不会报错
 func Disconnect(networkName string, cinfo *container.ContainerInfo) error {
 
-	return nil
+	epPath := path.Join(defaultEndpointPath, fmt.Sprintf("%s-%s", cinfo.Id, networkName))
+	ep := &Endpoint{}
+	if err := ep.load(epPath); err != nil {
+
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	network := ep.Network
+	if network == nil {
+
+		return fmt.Errorf("endpoint %s has no network", ep.ID)
+	}
+
+	if driver, ok := drivers[network.Driver]; ok {
+
+		if err := driver.Disconnect(*network, ep); err != nil {
+
+			logrus.Errorf("error disconnect endpoint %s: %v", ep.ID, err)
+		}
+	}
+
+	deletePortMapping(ep)
+
+	if err := ipAllocator.Release(network.IpRange, &ep.IPAddress); err != nil {
+
+		return err
+	}
+
+	return os.Remove(epPath)
+}
+
+//删除 configPortMapping 添加的 DNAT 规则
+func deletePortMapping(ep *Endpoint) {
+
+	for _, pm := range ep.PortMapping {
+
+		portMapping := strings.Split(pm, ":")
+		if len(portMapping) != 2 {
+			continue
+		}
+
+		iptablesCmd := fmt.Sprintf("-t nat -D PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
+			portMapping[0], ep.IPAddress.String(), portMapping[1])
+
+		if output, err := exec.Command("iptables", strings.Split(iptablesCmd, " ")...).CombinedOutput(); err != nil {
+
+			logrus.Errorf("iptables output, %s", output)
+		}
+	}
 }
\ No newline at end of file
@@ -7,6 +7,54 @@ package nsenter
 #include <stdlib.h>
 #include <string.h>
 #include <fcntl.h>
+#include <unistd.h>
+#include <grp.h>
+#include <ctype.h>
+#include <sys/wait.h>
+
+//ttdocker_cmd 中每个参数编码为 <长度>:<内容>, 参数中的空格和引号原样保留, 不需要经过 shell 解析
+//解析成功返回参数个数, argv 以 NULL 结尾; 格式错误时返回 -1
+static int parse_args(const char *cmd, char ***argvp) {
+
+	size_t len = strlen(cmd);
+	//每个参数至少占 "0:" 两个字符
+	char **argv = calloc(len / 2 + 1, sizeof(char *));
+	if (!argv) {
+
+		return -1;
+	}
+
+	int argc = 0;
+	const char *p = cmd;
+	const char *end = cmd + len;
+	while (p < end) {
+
+		char *colon;
+		if (!isdigit((unsigned char)*p)) {
+
+			goto fail;
+		}
+		errno = 0;
+		unsigned long n = strtoul(p, &colon, 10);
+		if (errno || *colon != ':' || n > (unsigned long)(end - colon - 1)) {
+
+			goto fail;
+		}
+		argv[argc++] = strndup(colon + 1, n);
+		p = colon + 1 + n;
+	}
+	argv[argc] = NULL;
+	*argvp = argv;
+	return argc;
+
+fail:
+	for (int i = 0; i < argc; i++) {
+
+		free(argv[i]);
+	}
+	free(argv);
+	return -1;
+}
 
 
 //　这里__attribute__((constructor)) 是指，一旦这个包被引用，　那么这个函数就会被自动执行
@@ -47,12 +95,114 @@ __attribute__((constructor)) void enter_namespace(void) {
 		}
 		close(fd);
 	}
-     //在进入的namespac 中执行指定的命令
-	system(ttdocker_cmd);
 
-	exit(0);
-	return;
+	//进入 mnt namespace 之后根目录已经是容器的根目录, 切换到容器的工作目录
+	char *ttdocker_cwd = getenv("ttdocker_cwd");
+	if (ttdocker_cwd && chdir(ttdocker_cwd) == -1) {
+
+		fprintf(stderr, "chdir %s error %s\n", ttdocker_cwd, strerror(errno));
+		exit(1);
+	}
+
+	//ttdocker_user 是已经解析好的 uid:gid, 先清空附加组和切换组, 最后切换用户
+	char *ttdocker_user = getenv("ttdocker_user");
+	if (ttdocker_user) {
+
+		unsigned int uid, gid;
+		if (sscanf(ttdocker_user, "%u:%u", &uid, &gid) != 2) {
+
+			fprintf(stderr, "invalid user %s\n", ttdocker_user);
+			exit(1);
+		}
+		if (setgroups(0, NULL) == -1 || setgid(gid) == -1 || setuid(uid) == -1) {
+
+			fprintf(stderr, "set user %s error %s\n", ttdocker_user, strerror(errno));
+			exit(1);
+		}
+	}
+	char **argv;
+	if (parse_args(ttdocker_cmd, &argv) <= 0) {
+
+		fprintf(stderr, "invalid command %s\n", ttdocker_cmd);
+		exit(1);
+	}
+
+	//setns 进入 pid namespace 之后只有新创建的子进程才在容器的 pid namespace 中
+	//所以 fork 之后在子进程中直接 execvp 用户命令, 父进程等待子进程退出并返回它的退出码
+	pid_t child = fork();
+	if (child == -1) {
+
+		fprintf(stderr, "fork error %s\n", strerror(errno));
+		exit(1);
+	}
+	if (child == 0) {
+
+		execvp(argv[0], argv);
+		fprintf(stderr, "exec %s error %s\n", argv[0], strerror(errno));
+		exit(127);
+	}
+
+	int status;
+	while (waitpid(child, &status, 0) == -1) {
+
+		if (errno != EINTR) {
+
+			exit(1);
+		}
+	}
+	//和 shell 一样, 被信号杀掉的进程退出码记为 128 + 信号值
+	if (WIFSIGNALED(status)) {
+
+		exit(128 + WTERMSIG(status));
+	}
+	exit(WEXITSTATUS(status));
 }
 
 */
 import "C"
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+//把用户命令的每个参数编码为 <长度>:<内容>, 通过环境变量 ttdocker_cmd 传给 C 代码
+//不能直接用空格拼接, 否则 sh -c "echo a b" 这种带空格的参数会被拆开
+func EncodeArgs(args []string) string {
+
+	var b strings.Builder
+	for _, arg := range args {
+
+		b.WriteString(strconv.Itoa(len(arg)))
+		b.WriteString(":")
+		b.WriteString(arg)
+	}
+
+	return b.String()
+}
+
+//用 C 中的 parse_args 解析 EncodeArgs 编码的命令
+func decodeArgs(encoded string) ([]string, error) {
+
+	cmd := C.CString(encoded)
+	defer C.free(unsafe.Pointer(cmd))
+
+	var argv **C.char
+	argc := int(C.parse_args(cmd, &argv))
+	if argc < 0 {
+
+		return nil, fmt.Errorf("invalid command %q", encoded)
+	}
+	defer C.free(unsafe.Pointer(argv))
+
+	args := make([]string, argc)
+	for i, arg := range unsafe.Slice(argv, argc) {
+
+		args[i] = C.GoString(arg)
+		C.free(unsafe.Pointer(arg))
+	}
+
+	return args, nil
+}
@@ -0,0 +1,50 @@
+package nsenter
+
+import (
+	"reflect"
+	"testing"
+)
+
+//exec 的命令经过编码和 C 代码解析之后, 每个参数保持原样
+func TestEncodeArgs(t *testing.T) {
+
+	tests := [][]string{
+		{"ls"},
+		{"sh", "-c", "echo a b"},
+		{"sh", "-c", `echo "a  b" 'c d'`},
+		{"echo", "", "  ", "\t"},
+		{"echo", "12:ab", "3:", ":"},
+		{"echo", "line1\nline2", `back\slash`},
+		{"echo", "$HOME", "`id`", "a;b", "a|b", "*"},
+		{"echo", "中文 参数"},
+	}
+
+	for _, args := range tests {
+
+		got, err := decodeArgs(EncodeArgs(args))
+		if err != nil {
+
+			t.Fatalf("decode %q error %v", args, err)
+		}
+		if !reflect.DeepEqual(got, args) {
+
+			t.Errorf("decode(encode(%q)) = %q", args, got)
+		}
+	}
+}
+
+func TestDecodeArgsInvalid(t *testing.T) {
+
+	for _, encoded := range []string{"ls", "2:ls -l", "3:ab", "-1:a", " 1:a", "1:a2"} {
+
+		if args, err := decodeArgs(encoded); err == nil {
+
+			t.Errorf("decode %q = %q, want error", encoded, args)
+		}
+	}
+
+	if args, err := decodeArgs(""); err != nil || len(args) != 0 {
+
+		t.Errorf("decode empty command = %q, %v", args, err)
+	}
+}
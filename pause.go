@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"ttdocker/cgroups"
+	"ttdocker/container"
+)
+
+//调用方式 ttdocker pause 容器名
+//通过 freezer 冻结容器 cgroup 中的所有进程
+func pauseContainer(containerName string) error {
+
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+
+	if err := container.CheckTransition(containerInfo, container.PAUSED); err != nil {
+
+		return err
+	}
+
+	if err := cgroups.NewCgroupManager(containerInfo.CgroupPath).Freeze(); err != nil {
+
+		return fmt.Errorf("pause container %s error %v", containerName, err)
+	}
+
+	containerInfo.Status = container.PAUSED
+	return writeContainerInfo(containerInfo)
+}
+
+//调用方式 ttdocker unpause 容器名
+//恢复被冻结的容器
+func unpauseContainer(containerName string) error {
+
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+
+	//created 转换到 running 需要 start, 这里只接受 paused
+	if err := container.RequireStatus(containerInfo, "unpause", container.PAUSED); err != nil {
+
+		return err
+	}
+
+	if err := cgroups.NewCgroupManager(containerInfo.CgroupPath).Thaw(); err != nil {
+
+		return fmt.Errorf("unpause container %s error %v", containerName, err)
+	}
+
+	containerInfo.Status = container.RUNNING
+	return writeContainerInfo(containerInfo)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//重启策略的名字, 和 docker 的 --restart 参数一致
+const (
+	restartNo 				= "no"
+	restartOnFailure 		= "on-failure"
+	restartAlways 			= "always"
+	restartUnlessStopped 	= "unless-stopped"
+)
+
+//两次重启之间的等待时间从 100ms 开始, 每次翻倍, 最多等待 1 分钟
+//容器运行超过 10s 才退出, 说明启动是成功的, 等待时间重新从 100ms 开始计算
+const (
+	restartBackoffMin 		= 100 * time.Millisecond
+	restartBackoffMax 		= time.Minute
+	restartBackoffReset 	= 10 * time.Second
+)
+
+type restartPolicy struct {
+	Name 				string
+	MaximumRetryCount 	int    //on-failure:N 中的 N, 0 表示不限制重启次数
+}
+
+//解析 --restart 参数, 格式为 no, always, unless-stopped 或者 on-failure[:N]
+func parseRestartPolicy(policy string) (*restartPolicy, error) {
+
+	if policy == "" {
+
+		return &restartPolicy{Name: restartNo}, nil
+	}
+
+	parts := strings.SplitN(policy, ":", 2)
+	p := &restartPolicy{Name: parts[0]}
+
+	switch p.Name {
+
+	case restartNo, restartAlways, restartUnlessStopped:
+		if len(parts) == 2 {
+
+			return nil, fmt.Errorf("maximum retry count cannot be used with restart policy %s", p.Name)
+		}
+	case restartOnFailure:
+		if len(parts) == 2 {
+
+			count, err := strconv.Atoi(parts[1])
+			if err != nil || count < 0 {
+
+				return nil, fmt.Errorf("invalid maximum retry count %s", parts[1])
+			}
+			p.MaximumRetryCount = count
+		}
+	default:
+		return nil, fmt.Errorf("invalid restart policy %s", policy)
+	}
+
+	return p, nil
+}
+
+//根据容器的退出码和已经重启的次数判断是否需要重启容器, 被 stop 停止的容器不会重启
+//ttdocker 没有常驻的 daemon, always 和 unless-stopped 只在宿主机重启之后才有区别, 这里两者的行为相同
+func (p *restartPolicy) shouldRestart(exitCode int, restartCount int, manuallyStopped bool) bool {
+
+	if manuallyStopped {
+
+		return false
+	}
+
+	switch p.Name {
+
+	case restartAlways, restartUnlessStopped:
+		return true
+	case restartOnFailure:
+		if exitCode == 0 {
+
+			return false
+		}
+		return p.MaximumRetryCount == 0 || restartCount < p.MaximumRetryCount
+	}
+
+	return false
+}
+
+//根据上一次的等待时间和容器这次运行的时间, 计算下一次重启前的等待时间
+func nextRestartBackoff(backoff time.Duration, running time.Duration) time.Duration {
+
+	if backoff == 0 || running >= restartBackoffReset {
+
+		return restartBackoffMin
+	}
+
+	backoff *= 2
+	if backoff > restartBackoffMax {
+
+		backoff = restartBackoffMax
+	}
+
+	return backoff
+}
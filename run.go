@@ -4,31 +4,285 @@ import (
 	"encoding/json"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
 	"ttdocker/network"
 	"ttdocker/cgroups"
 	"ttdocker/cgroups/subsystems"
 	"ttdocker/container"
+	"ttdocker/image"
 	"math/rand"
 	"os"
+	"os/exec"
+	"path"
 	"strconv"
 	"strings"
 	"time"
 )
 
-func Run(tty bool, comArray []string, res *subsystems.ResourceConfig, volume, containerName , imageName string, envSlice []string, nw string, portmapping []string){
+//从 run 和 create 的命令行参数中解析出启动容器需要的全部参数
+func parseRunSpec(context *cli.Context) (*runSpec, error) {
+
+	if len(context.Args()) < 1 {
+
+		return nil, fmt.Errorf("missing image name")
+	}
+
+	var cmdArray []string
+
+	for _, arg := range context.Args(){
+
+		cmdArray = append(cmdArray, arg)
+
+	}
+
+	createTty := context.Bool("ti")
+	detach := context.Bool("d")
+
+	if createTty && detach {
+
+		return nil, fmt.Errorf("ti and d paramter can not both provided")
+	}
+
+	//只有后台运行的容器才有 shim 看管, 才能自动重启
+	restart := context.String("restart")
+	policy, err := parseRestartPolicy(restart)
+	if err != nil {
+
+		return nil, err
+	}
+	if policy.Name != restartNo {
+
+		if createTty {
+
+			return nil, fmt.Errorf("restart policy can only be used with detached containers")
+		}
+		if context.Bool("rm") {
+
+			return nil, fmt.Errorf("restart policy and rm can not both provided")
+		}
+	}
+
+	resConf := &subsystems.ResourceConfig{
+
+		//取出各个字段对应的参数值
+		MemoryLimit: context.String("m"),
+		MemorySwap: context.String("memory-swap"),
+		MemoryReservation: context.String("memory-reservation"),
+		OomKillDisable: context.Bool("oom-kill-disable"),
+		CpuSet: context.String("cpuset"),
+		CpuShare: context.String("cpushare"),
+		Cpus: context.String("cpus"),
+		CpuQuota: context.String("cpu-quota"),
+		CpuPeriod: context.String("cpu-period"),
+		PidsLimit: context.String("pids-limit"),
+		BlkioWeight: context.String("blkio-weight"),
+		BlkioDeviceReadBps: context.StringSlice("device-read-bps"),
+		BlkioDeviceWriteBps: context.StringSlice("device-write-bps"),
+		BlkioDeviceReadIops: context.StringSlice("device-read-iops"),
+		BlkioDeviceWriteIops: context.StringSlice("device-write-iops"),
+	}
+
+	//解析 512m 这种带单位的参数, 并检查各个限制之间是否冲突
+	if err := resConf.Validate(); err != nil {
+
+		return nil, err
+	}
 
 	containerID := randStringBytes(10)
+	containerName := context.String("name")
 	if containerName == "" {
 
 		containerName = containerID
 	}
 
+	//-u 的格式在这里检查, 用户名要等切换根目录之后在容器内解析
+	user := context.String("u")
+	if user != "" {
+
+		if ids := strings.SplitN(user, ":", 2); ids[0] == "" || (len(ids) == 2 && ids[1] == "") {
+
+			return nil, fmt.Errorf("invalid user %s, format: <name|uid>[:<group|gid>]", user)
+		}
+	}
+
+	workingDir := context.String("w")
+	if workingDir != "" && !path.IsAbs(workingDir) {
+
+		return nil, fmt.Errorf("working directory %s is not an absolute path", workingDir)
+	}
+
+	//和 docker 一样, 没有指定主机名时使用容器 ID
+	hostname := context.String("hostname")
+	if hostname == "" {
+
+		hostname = containerID
+	}
+
+	//标签的格式为 key=value, 只写 key 时 value 为空
+	labels := map[string]string{}
+	for _, label := range context.StringSlice("label") {
+
+		kv := strings.SplitN(label, "=", 2)
+		if kv[0] == "" {
+
+			return nil, fmt.Errorf("invalid label %s, format: key[=value]", label)
+		}
+		if len(kv) == 1 {
+
+			kv = append(kv, "")
+		}
+		labels[kv[0]] = kv[1]
+	}
+
+	//没有指定 --storage-driver 时根据内核支持的文件系统选择存储驱动
+	storageDriver := context.GlobalString("storage-driver")
+	if storageDriver == "" {
+
+		if storageDriver, err = container.DetectStorageDriver(); err != nil {
+
+			return nil, err
+		}
+	} else if _, err := container.GetStorageDriver(storageDriver); err != nil {
+
+		return nil, err
+	}
+
+	//先确认镜像存在, 记录镜像 ID, 以后镜像名指向其他镜像时容器重启仍然使用这个镜像
+	img, err := image.Get(cmdArray[0])
+	if err != nil {
+
+		return nil, err
+	}
+
+	//命令行没有指定的参数使用镜像中的配置
+	config := img.Config
+	if config == nil {
+
+		config = &image.Config{}
+	}
+	command := mergeImageCommand(config, cmdArray[1:], context.IsSet("entrypoint"), context.String("entrypoint"))
+	if len(command) == 0 {
+
+		return nil, fmt.Errorf("missing container command, image %s has no default command", cmdArray[0])
+	}
+	if user == "" {
+
+		user = config.User
+	}
+	if workingDir == "" {
+
+		workingDir = config.WorkingDir
+	}
+
+	//容器名用作容器信息目录和工作目录的名字, 不能和已有的容器重复
+	if _, err := os.Stat(fmt.Sprintf(container.DefaultInfoLocation, containerName) + container.ConfigName); err == nil {
+
+		return nil, fmt.Errorf("container name %s is already in use", containerName)
+	}
+
+	return &runSpec{
+
+		Id: containerID,
+		Name: containerName,
+		Image: cmdArray[0],
+		ImageID: img.ID,
+		Tty: createTty,
+		Cmd: command,
+		//-e 指定的环境变量覆盖镜像中的同名变量
+		Env: image.MergeEnv(config.Env, context.StringSlice("e")),
+		Volume: context.String("v"),
+		Network: context.String("net"),
+		PortMapping: context.StringSlice("p"),
+		//指定了 cgroup-parent 时, 容器的 cgroup 创建在父 cgroup 下, 例如 ttdocker.slice/<id>
+		CgroupPath: path.Join(context.String("cgroup-parent"), containerID),
+		Resource: resConf,
+		AutoRemove: context.Bool("rm"),
+		Init: context.Bool("init"),
+		User: user,
+		WorkingDir: workingDir,
+		Hostname: hostname,
+		Labels: labels,
+		ExposedPorts: config.Ports(),
+		StorageDriver: storageDriver,
+		//以当前时间为容器创建时间, 容器重启时不变, ps 根据它显示容器创建了多久
+		CreatedTime: time.Now().Format(time.RFC3339),
+		RestartPolicy: restart,
+	}, nil
+}
+
+func Run(spec *runSpec){
+
+	//后台运行的容器交给 shim 进程启动和看管, 当前进程等容器启动之后就返回
+	if !spec.Tty {
+
+		if err := startShim(spec); err != nil {
+
+			log.Errorf("start container %s error %v", spec.Name, err)
+		}
+		return
+	}
+
+	parent, writePipe, err := launchContainer(spec, container.RUNNING)
+	if err != nil {
+
+		//和 shim 一样, 新建容器失败时删除容器记录和已经创建的可写层
+		log.Errorf("launch container %s error %v", spec.Name, err)
+		deleteContainerInfo(spec.Name)
+		container.DeleteWorkSpace(spec.Volume, spec.Name, spec.StorageDriver)
+		return
+	}
+
+	//对容器设置完限制之后，初始化容器
+	//发送用户命令
+	sendInitCommand(spec, writePipe)
+
+	//　阻塞在这
+	parent.Wait()
+	if spec.Network != "" {
+
+		network.Disconnect(spec.Network, &container.ContainerInfo{Id: spec.Id, Name: spec.Name})
+	}
+	cgroups.NewCgroupManager(spec.CgroupPath).Destroy()
+	deleteContainerInfo(spec.Name)
+	container.DeleteWorkSpace(spec.Volume, spec.Name, spec.StorageDriver)
+}
+
+//准备好容器的工作目录, cgroup 和网络, 容器进程停在 init 管道上, 由 shim 看管, 等待 start 命令
+func createContainer(spec *runSpec) error {
+
+	spec.Tty = false
+	spec.Create = true
+	if err := startShim(spec); err != nil {
+
+		return fmt.Errorf("create container %s error %v", spec.Name, err)
+	}
+
+	//和 docker create 一样输出容器 ID
+	fmt.Println(spec.Id)
+	return nil
+}
+
+//启动容器进程, 以 status 状态记录容器信息, 设置 cgroup 和网络
+//返回容器的 init 进程和 init 管道写的一端, 调用者通过 sendInitCommand 发送用户命令之后容器才真正开始运行
+func launchContainer(spec *runSpec, status string) (*exec.Cmd, *os.File, error) {
+
+	img, err := getContainerImage(spec.ImageID, spec.Image)
+	if err != nil {
+
+		return nil, nil, err
+	}
+
+	//不同存储驱动使用的 whiteout 格式不同, 各自使用自己的解压目录
+	if err := img.Unpack(spec.StorageDriver); err != nil {
+
+		return nil, nil, err
+	}
+
 	//将环境变量传递给 process
-	parent, writePipe := container.NewParentProcess(tty, volume, containerName, imageName, envSlice)
+	parent, writePipe := container.NewParentProcess(spec.Tty, spec.Volume, spec.Name, img.LayerDirs(spec.StorageDriver), spec.Env, spec.Init, spec.StorageDriver)
 	if parent == nil {
 
-		log.Errorf("new parent process error")
-		return
+		return nil, nil, fmt.Errorf("new parent process error")
 	}
 
 	//start 调用前面创建好的command 命令
@@ -36,103 +290,182 @@ func Run(tty bool, comArray []string, res *subsystems.ResourceConfig, volume, co
 	//首先会clone 出一个namspace 隔离的进程, 然后在子进程中,调用/proc/self/exe  调用自己, 发送init 参数
 	if err := parent.Start(); err != nil {
 
-		log.Error(err)
+		return nil, nil, fmt.Errorf("start parent process error %v", err)
+	}
+
+	//后面任何一步失败, 都要杀掉已经启动的容器进程
+	fail := func(err error) (*exec.Cmd, *os.File, error) {
+
+		writePipe.Close()
+		parent.Process.Kill()
+		parent.Wait()
+		return nil, nil, err
 	}
 
 	//记录容器信息
-	containerName, err := recordContainerInfo(parent.Process.Pid, comArray, containerName, containerID, volume)
-	if err != nil {
+	if err := recordContainerInfo(parent.Process.Pid, spec, status); err != nil {
 
-		log.Errorf("recode container info error %v", err)
-		return
+		return fail(fmt.Errorf("recode container info error %v", err))
 	}
 
 	// use mydocker-cgroup as cgroup name
 	//创建 cgroup manager ，并通过调用 set 和 apply 设置资源限制并使限制在容器上生效
-	cgroupsManager := cgroups.NewCgroupManager(containerID)
-	defer cgroupsManager.Destroy()
-	//设置资源限制
-	cgroupsManager.Set(res)
+	//cgroup 的路径和资源限制都已经记录在容器信息中, 后台运行的容器在退出、stop 或者 rm 时再释放 cgroup
+	cgroupsManager := cgroups.NewCgroupManager(spec.CgroupPath)
+	//设置资源限制, 限制没有生效时不能让容器不受限制地运行
+	if err := cgroupsManager.Set(spec.Resource); err != nil {
+
+		cgroupsManager.Destroy()
+		return fail(fmt.Errorf("set cgroup resource error %v", err))
+	}
 	//将容器进程加入到各个 subsystem 挂载对应的 cgroup
 	cgroupsManager.Apply(parent.Process.Pid)
 
-	if nw != "" {
+	if spec.Network != "" {
 
 		//config container network
 		network.Init()
 		containerInfo := &container.ContainerInfo{
 
-			Id: containerID,
+			Id: spec.Id,
 			Pid: strconv.Itoa(parent.Process.Pid),
-			Name: containerName,
-			PortMapping: portmapping,
+			Name: spec.Name,
+			PortMapping: spec.PortMapping,
 		}
 
-		if err := network.Connect(nw, containerInfo); err != nil {
+		if err := network.Connect(spec.Network, containerInfo); err != nil {
 
-			log.Errorf("error connect network %v", err)
-			return
+			cgroupsManager.Destroy()
+			return fail(fmt.Errorf("error connect network %v", err))
 		}
 	}
 
-	//对容器设置完限制之后，初始化容器
-	//发送用户命令
-	sendInitCommand(comArray, writePipe)
-	//　阻塞在这
-	if tty {
+	return parent, writePipe, nil
+}
 
-		parent.Wait()
-		deleteContainerInfo(containerName)
-		container.DeleteWorkSpace(volume,containerName)
+/*
+	和 docker 一样合并用户命令和镜像的 Entrypoint 和 Cmd
+	1.命令行指定的命令替换镜像的 Cmd, 没有指定时使用镜像的 Cmd
+	2.--entrypoint 替换镜像的 Entrypoint, 同时不再使用镜像的 Cmd
+	3.最终的命令为 Entrypoint + Cmd
+*/
+func mergeImageCommand(config *image.Config, args []string, entrypointSet bool, entrypoint string) []string {
+
+	imageEntrypoint := config.Entrypoint
+	cmd := config.Cmd
+	if entrypointSet {
+
+		imageEntrypoint = nil
+		if entrypoint != "" {
+
+			imageEntrypoint = []string{entrypoint}
+		}
+		cmd = nil
+	}
+	if len(args) > 0 {
+
+		cmd = args
 	}
+
+	return append(append([]string{}, imageEntrypoint...), cmd...)
 }
 
-func sendInitCommand(comArray []string, writePipe *os.File){
+//按镜像 ID 查找容器使用的镜像, 没有记录镜像 ID 的旧容器按镜像名查找
+func getContainerImage(imageID string, imageName string) (*image.Image, error) {
+
+	if imageID != "" {
 
-	command := strings.Join(comArray, " ")
-	log.Infof("command all is %s", command)
+		return image.Get(imageID)
+	}
+
+	return image.Get(imageName)
+}
+
+//把用户命令和运行参数编码成 json 发送给容器的 init 进程, 关闭管道之后 init 才会读到完整的内容
+func sendInitCommand(spec *runSpec, writePipe *os.File){
+
+	initSpec := &container.InitSpec{
+
+		Args: spec.Cmd,
+		//和 NewParentProcess 中 init 进程的环境变量一致
+		Env: append(os.Environ(), spec.Env...),
+		Cwd: spec.WorkingDir,
+		User: spec.User,
+		Hostname: spec.Hostname,
+		Mounts: container.DefaultMounts(),
+	}
+	log.Infof("command all is %q", initSpec.Args)
 
-	writePipe.WriteString(command)
+	if err := container.WriteInitSpec(writePipe, initSpec); err != nil {
+
+		log.Errorf("send init spec error %v", err)
+	}
 	writePipe.Close()
 }
 
 //记录容器信息,将容器的信息持久化到磁盘中
-func recordContainerInfo (containerPID int, commandArray []string, containerName , id , volume string) (string, error){
+func recordContainerInfo (containerPID int, spec *runSpec, status string) error {
 
-	//以当前时间为容器创建时间
-	createTime := time.Now().Format("2020-08-28 13:08:00")
-	command := strings.Join(commandArray, "")
+	command := strings.Join(spec.Cmd, " ")
 
 	//生成容器信息的结构体实例
 	containerInfo := &container.ContainerInfo{
 
-		Id: id,
+		Id: spec.Id,
 		Pid: strconv.Itoa(containerPID),
 		Command: command,
-		CreatedTime: createTime,
-		Status: container.RUNNING,
-		Name: containerName,
-		Volume: volume,
+		CreatedTime: spec.CreatedTime,
+		Status: status,
+		Name: spec.Name,
+		Volume: spec.Volume,
+		PortMapping: spec.PortMapping,
+		CgroupPath: spec.CgroupPath,
+		Resource: spec.Resource,
+		RestartPolicy: spec.RestartPolicy,
+		RestartCount: spec.RestartCount,
+		//保存完整的运行参数, stop 之后可以用 start 重新启动容器
+		Image: spec.Image,
+		ImageID: spec.ImageID,
+		Args: spec.Cmd,
+		Env: spec.Env,
+		Network: spec.Network,
+		AutoRemove: spec.AutoRemove,
+		Init: spec.Init,
+		User: spec.User,
+		WorkingDir: spec.WorkingDir,
+		Hostname: spec.Hostname,
+		Labels: spec.Labels,
+		ExposedPorts: spec.ExposedPorts,
+		StorageDriver: spec.StorageDriver,
+	}
+
+	//shim 自动重启容器时 stop 可能刚刚写入了手动停止的标记, 保留这个标记, shim 启动之后会检查
+	if spec.RestartCount > 0 {
+
+		if oldInfo, err := getContainerInfoByName(spec.Name); err == nil {
+
+			containerInfo.ManuallyStopped = oldInfo.ManuallyStopped
+		}
 	}
 
 	//将容器信息对象 json 序列化成字符串
 	jsonBytes, err := json.Marshal(containerInfo)
 	if err != nil {
 
-		log.Error("Record container info error %v", err)
-		return "",err
+		log.Errorf("Record container info error %v", err)
+		return err
 	}
 
 	jsonStr := string(jsonBytes)
 
 	//拼凑一下存储容器信息的路径
-	dirUrl := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	dirUrl := fmt.Sprintf(container.DefaultInfoLocation, spec.Name)
 
 	//如果改路径不存在，级联创建
 	if err := os.MkdirAll(dirUrl, 0622); err != nil {
 
 		log.Errorf("mkdir error %s error %v", dirUrl, err)
-		return "",err
+		return err
 	}
 
 	fileName := dirUrl + "/" + container.ConfigName
@@ -141,7 +474,7 @@ func recordContainerInfo (containerPID int, commandArray []string, containerName
 	if err != nil {
 
 		log.Errorf("create file %s error %v", fileName, err)
-		return "", err
+		return err
 	}
 
 	defer file.Close()
@@ -150,10 +483,10 @@ func recordContainerInfo (containerPID int, commandArray []string, containerName
 	if _, err := file.WriteString(jsonStr); err != nil {
 
 		log.Errorf("file write string error %v", err)
-		return "", err
+		return err
 	}
 
-	return containerName, nil
+	return nil
 }
 
 //ID 生成器
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+	"ttdocker/cgroups/subsystems"
+	"ttdocker/container"
+	"ttdocker/network"
+)
+
+//shim 启动成功后通过管道回复给 run 的内容, 其他内容都是启动失败的原因
+const shimReady = "ok"
+
+//启动一个容器需要的全部参数, 后台运行时通过管道传给 shim 进程
+type runSpec struct {
+	Id 			string `json:"id"`
+	Name 		string `json:"name"`
+	Image 		string `json:"image"`
+	Tty 		bool `json:"tty"`
+	Cmd 		[]string `json:"cmd"`
+	Env 		[]string `json:"env"`
+	Volume 		string `json:"volume"`
+	Network 	string `json:"network"`
+	PortMapping []string `json:"portmapping"`
+	CgroupPath 	string `json:"cgroupPath"`
+	Resource 	*subsystems.ResourceConfig `json:"resource"`
+	AutoRemove 	bool `json:"autoRemove"`  //容器退出后删除容器记录和工作目录
+	CreatedTime string `json:"createTime"`
+	RestartPolicy string `json:"restartPolicy"`
+	RestartCount int `json:"restartCount"`  //shim 已经重启容器的次数
+	Create 		bool `json:"create"`  //容器创建之后等待 start 命令, 收到之后才发送用户命令
+	Relaunch 	bool `json:"relaunch"`  //重新启动已经停止的容器, 启动失败时保留容器
+	Init 		bool `json:"init"`  //容器内运行 init 进程转发信号和回收僵尸进程
+	User 		string `json:"user"`
+	WorkingDir 	string `json:"workingDir"`
+	Hostname 	string `json:"hostname"`
+	Labels 		map[string]string `json:"labels"`
+	ExposedPorts []string `json:"exposedPorts"`
+	StorageDriver string `json:"storageDriver"`
+	ImageID 	string `json:"imageId"`
+}
+
+/*
+	后台运行的容器由一个 shim 进程看管, 和 init 一样, shim 也是通过 /proc/self/exe 调用自己启动的
+	1.run 通过 fd 3 把 runSpec 发给 shim, shim 启动容器之后通过 fd 4 回复启动结果
+	2.shim 调用 setsid 脱离 run 所在的终端和会话, run 返回之后 shim 继续等待容器退出
+	3.容器退出后 shim 记录退出码和退出时间, 释放 cgroup, 指定了 --rm 时删除容器
+*/
+func startShim(spec *runSpec) error {
+
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, spec.Name)
+	if err := os.MkdirAll(dirURL, 0622); err != nil {
+
+		return fmt.Errorf("mkdir %s error %v", dirURL, err)
+	}
+
+	//shim 的日志单独写到 shim.log 中, 不和容器的输出混在一起, 重新启动时接着写
+	logFile, err := os.OpenFile(dirURL + container.ShimLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+
+		return fmt.Errorf("create shim log file error %v", err)
+	}
+	defer logFile.Close()
+
+	specReadPipe, specWritePipe, err := container.NewPipe()
+	if err != nil {
+
+		return err
+	}
+	readyReadPipe, readyWritePipe, err := container.NewPipe()
+	if err != nil {
+
+		return err
+	}
+
+	cmd := exec.Command("/proc/self/exe", "shim")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+
+		Setsid: true,
+	}
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.ExtraFiles = []*os.File{specReadPipe, readyWritePipe}
+
+	if err := cmd.Start(); err != nil {
+
+		return fmt.Errorf("start shim error %v", err)
+	}
+
+	//关闭子进程使用的一端, 这样 shim 退出时这边能读到 EOF
+	specReadPipe.Close()
+	readyWritePipe.Close()
+
+	if err := json.NewEncoder(specWritePipe).Encode(spec); err != nil {
+
+		specWritePipe.Close()
+		return fmt.Errorf("send spec to shim error %v", err)
+	}
+	specWritePipe.Close()
+
+	reply, err := ioutil.ReadAll(readyReadPipe)
+	readyReadPipe.Close()
+	if err != nil {
+
+		return fmt.Errorf("read shim reply error %v", err)
+	}
+
+	switch string(reply) {
+
+	case shimReady:
+		//shim 之后不再由 run 等待, 交给 init 进程回收
+		return cmd.Process.Release()
+	case "":
+		cmd.Wait()
+		return fmt.Errorf("shim exited unexpectedly, see %s", dirURL + container.ShimLogFile)
+	default:
+		cmd.Wait()
+		return fmt.Errorf("%s", reply)
+	}
+}
+
+//shim 进程的入口, 启动容器并一直等到容器退出
+func runShim() error {
+
+	//继承来的文件描述符没有 close-on-exec 标记, 不设置的话会被容器进程继承, run 就一直读不到 EOF
+	syscall.CloseOnExec(3)
+	syscall.CloseOnExec(4)
+	specPipe := os.NewFile(uintptr(3), "pipe")
+	readyPipe := os.NewFile(uintptr(4), "pipe")
+
+	var spec runSpec
+	err := json.NewDecoder(specPipe).Decode(&spec)
+	specPipe.Close()
+	if err != nil {
+
+		readyPipe.WriteString(fmt.Sprintf("decode spec error %v", err))
+		readyPipe.Close()
+		return err
+	}
+
+	//started 在用户命令发送给容器之后关闭, 只有真正运行过的容器才会按照重启策略重启
+	started := make(chan struct{})
+	status := container.RUNNING
+	startFifo := fmt.Sprintf(container.DefaultInfoLocation, spec.Name) + container.StartFifoName
+	var fifo *os.File
+	if spec.Create {
+
+		status = container.CREATED
+		//在报告 ready 之前就打开读的一端, 否则 start 以非阻塞方式打开写端时 shim 可能还没有打开 fifo, 会返回 ENXIO
+		//以读写方式打开不会阻塞, 而且 shim 自己也持有写端, 读的时候不会因为没有写端而读到 EOF
+		if err = syscall.Mkfifo(startFifo, 0622); err != nil {
+
+			err = fmt.Errorf("mkfifo %s error %v", startFifo, err)
+		} else if fifo, err = os.OpenFile(startFifo, os.O_RDWR, 0); err != nil {
+
+			err = fmt.Errorf("open start fifo %s error %v", startFifo, err)
+		}
+	}
+
+	//容器 stop 之后文件系统还挂载着, 先卸载, 再在保留下来的可写层上重新挂载
+	if spec.Relaunch {
+
+		container.UnmountWorkSpace(spec.Volume, spec.Name)
+	}
+
+	var parent *exec.Cmd
+	var writePipe *os.File
+	if err == nil {
+
+		parent, writePipe, err = launchContainer(&spec, status)
+	}
+	if err != nil {
+
+		if fifo != nil {
+
+			fifo.Close()
+		}
+		readyPipe.WriteString(err.Error())
+		readyPipe.Close()
+		//重新启动失败时保留容器, 只把容器标记为退出
+		if spec.Relaunch {
+
+			if containerInfo, err := getContainerInfoByName(spec.Name); err == nil {
+
+				markContainerExited(containerInfo)
+			}
+			return err
+		}
+		deleteContainerInfo(spec.Name)
+		container.DeleteWorkSpace(spec.Volume, spec.Name, spec.StorageDriver)
+		return err
+	}
+
+	if spec.Create {
+
+		go waitStartSignal(&spec, fifo, writePipe, started)
+	} else {
+
+		sendInitCommand(&spec, writePipe)
+		close(started)
+	}
+
+	readyPipe.WriteString(shimReady)
+	readyPipe.Close()
+	log.Infof("container %s %s, pid %d", spec.Name, status, parent.Process.Pid)
+
+	//run 已经检查过重启策略, 这里不会出错
+	policy, _ := parseRestartPolicy(spec.RestartPolicy)
+
+	var backoff time.Duration
+	for {
+
+		startedTime := time.Now()
+		//容器进程是 shim 的子进程, 由 shim 回收, 不会变成僵尸进程
+		parent.Wait()
+		exitCode := exitCodeOf(parent.ProcessState)
+		log.Infof("container %s exited with code %d", spec.Name, exitCode)
+
+		containerInfo := recordContainerExit(&spec, exitCode)
+		if containerInfo == nil || !isClosed(started) || !policy.shouldRestart(exitCode, spec.RestartCount, containerInfo.ManuallyStopped) {
+			break
+		}
+
+		backoff = nextRestartBackoff(backoff, time.Since(startedTime))
+		log.Infof("restart container %s in %v", spec.Name, backoff)
+		time.Sleep(backoff)
+
+		//等待期间容器可能被 stop, rm 或者 start 了
+		containerInfo, err = getContainerInfoByName(spec.Name)
+		if err != nil || containerInfo.ManuallyStopped || containerInfo.Status != container.Exit {
+			break
+		}
+
+		//update 修改的资源限制只保存在 config.json 中, 按照 config.json 重新生成启动参数, 不使用内存中旧的参数
+		restartCount := spec.RestartCount + 1
+		spec = *runSpecFromContainerInfo(containerInfo)
+		spec.RestartCount = restartCount
+
+		//在保留下来的可写层上重新挂载容器的文件系统, 然后重新启动容器
+		container.UnmountWorkSpace(spec.Volume, spec.Name)
+		if parent, writePipe, err = launchContainer(&spec, container.RUNNING); err != nil {
+
+			log.Errorf("restart container %s error %v", spec.Name, err)
+			recordContainerExit(&spec, exitCode)
+			break
+		}
+		sendInitCommand(&spec, writePipe)
+
+		//检查之后到重新启动之前容器可能被 stop 了, 这时杀掉刚启动的进程, 容器退出后保持 stopped 状态
+		if containerInfo, err = getContainerInfoByName(spec.Name); err == nil && containerInfo.ManuallyStopped {
+
+			log.Infof("container %s was stopped while restarting", spec.Name)
+			syscall.Kill(parent.Process.Pid, syscall.SIGKILL)
+			continue
+		}
+		log.Infof("container %s restarted %d times, pid %d", spec.Name, spec.RestartCount, parent.Process.Pid)
+	}
+
+	if spec.AutoRemove {
+
+		deleteContainerInfo(spec.Name)
+		container.DeleteWorkSpace(spec.Volume, spec.Name, spec.StorageDriver)
+	}
+
+	return nil
+}
+
+//等待 start 命令写 fifo, 然后把容器改为 running 状态并发送用户命令
+func waitStartSignal(spec *runSpec, fifo *os.File, writePipe *os.File, started chan struct{}) {
+
+	//shim 自己持有写端, 读不到 EOF, 一直阻塞到 start 写入内容
+	buf := make([]byte, 64)
+	if _, err := fifo.Read(buf); err != nil {
+
+		log.Errorf("read start fifo %s error %v", fifo.Name(), err)
+		return
+	}
+	fifo.Close()
+	os.Remove(fifo.Name())
+
+	//先改状态再发送命令, 避免容器很快退出时 shim 写入的 exited 被覆盖
+	containerInfo, err := getContainerInfoByName(spec.Name)
+	if err != nil {
+
+		log.Errorf("get container %s info error %v", spec.Name, err)
+		return
+	}
+	containerInfo.Status = container.RUNNING
+	if err := writeContainerInfo(containerInfo); err != nil {
+
+		log.Errorf("update container %s info error %v", spec.Name, err)
+		return
+	}
+
+	sendInitCommand(spec, writePipe)
+	close(started)
+	log.Infof("container %s started", spec.Name)
+}
+
+func isClosed(ch chan struct{}) bool {
+
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+//把容器的退出码和退出时间写回 config.json, 释放容器的 cgroup 和网络, 返回更新后的容器信息
+func recordContainerExit(spec *runSpec, exitCode int) *container.ContainerInfo {
+
+	//容器的 veth 随着网络 namespace 一起销毁了, 这里释放容器的 IP 和端口映射
+	if spec.Network != "" {
+
+		network.Init()
+		if err := network.Disconnect(spec.Network, &container.ContainerInfo{Id: spec.Id, Name: spec.Name}); err != nil {
+
+			log.Errorf("disconnect container %s from network %s error %v", spec.Name, spec.Network, err)
+		}
+	}
+
+	containerInfo, err := getContainerInfoByName(spec.Name)
+	if err != nil {
+
+		//容器记录已经被删除, 只释放 cgroup
+		log.Errorf("get container %s info error %v", spec.Name, err)
+		if err := destroyContainerCgroup(&container.ContainerInfo{Name: spec.Name, CgroupPath: spec.CgroupPath}); err != nil {
+
+			log.Errorf("destroy container %s cgroup error %v", spec.Name, err)
+		}
+		return nil
+	}
+
+	containerInfo.ExitCode = exitCode
+	containerInfo.FinishedTime = time.Now().Format(time.RFC3339)
+	markContainerExited(containerInfo)
+
+	return containerInfo
+}
+
+//和 shell 一样, 被信号杀掉的进程退出码记为 128 + 信号值
+func exitCodeOf(state *os.ProcessState) int {
+
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+
+		return 128 + int(status.Signal())
+	}
+
+	return state.ExitCode()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"ttdocker/cgroups/subsystems"
+	"ttdocker/container"
+)
+
+//调用方式 ttdocker start 容器名
+//启动 create 创建的容器, 或者用原来的参数重新启动已经停止的容器
+func startContainer(containerName string) error {
+
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+
+	//paused 转换到 running 需要 unpause, 这里只接受 created, stopped 和 exited
+	if err := container.RequireStatus(containerInfo, "start", container.CREATED, container.STOP, container.Exit); err != nil {
+
+		return err
+	}
+
+	if containerInfo.Status == container.CREATED {
+
+		return startCreatedContainer(containerName)
+	}
+
+	return relaunchContainer(containerInfo)
+}
+
+//create 创建的容器由 shim 看管, shim 阻塞在容器目录下的 start.fifo 上, 向 fifo 写入内容之后 shim 把用户命令发送给容器
+func startCreatedContainer(containerName string) error {
+
+	//以非阻塞方式打开, shim 已经退出时 fifo 没有读的一端, 会返回 ENXIO 而不是一直阻塞
+	startFifo := fmt.Sprintf(container.DefaultInfoLocation, containerName) + container.StartFifoName
+	fifo, err := os.OpenFile(startFifo, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+
+		return fmt.Errorf("container %s is not waiting to be started %v", containerName, err)
+	}
+	if _, err := fifo.Write([]byte("start")); err != nil {
+
+		fifo.Close()
+		return fmt.Errorf("start container %s error %v", containerName, err)
+	}
+	fifo.Close()
+
+	//shim 收到之后会把容器改为 running 状态, 等待状态改变之后再返回
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+
+		containerInfo, err := getContainerInfoByName(containerName)
+		if err == nil && containerInfo.Status != container.CREATED {
+
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return fmt.Errorf("timeout waiting for container %s to start", containerName)
+}
+
+//用 config.json 中保存的运行参数重新启动已经停止的容器
+//容器的可写层, cgroup 路径, 资源限制, 网络, 端口映射和数据卷都和原来一样, 由新的 shim 看管
+func relaunchContainer(containerInfo *container.ContainerInfo) error {
+
+	if containerInfo.Image == "" {
+
+		return fmt.Errorf("container %s has no run spec recorded, it can not be started again", containerInfo.Name)
+	}
+
+	spec := runSpecFromContainerInfo(containerInfo)
+	spec.Relaunch = true
+
+	if err := startShim(spec); err != nil {
+
+		return fmt.Errorf("start container %s error %v", containerInfo.Name, err)
+	}
+
+	return nil
+}
+
+//按照 config.json 中保存的运行参数重新生成启动参数, update 修改过的资源限制也保存在 config.json 中
+func runSpecFromContainerInfo(containerInfo *container.ContainerInfo) *runSpec {
+
+	spec := &runSpec{
+
+		Id: containerInfo.Id,
+		Name: containerInfo.Name,
+		Image: containerInfo.Image,
+		ImageID: containerInfo.ImageID,
+		Cmd: containerInfo.Args,
+		Env: containerInfo.Env,
+		Volume: containerInfo.Volume,
+		Network: containerInfo.Network,
+		PortMapping: containerInfo.PortMapping,
+		CgroupPath: containerInfo.CgroupPath,
+		Resource: containerInfo.Resource,
+		AutoRemove: containerInfo.AutoRemove,
+		CreatedTime: containerInfo.CreatedTime,
+		RestartPolicy: containerInfo.RestartPolicy,
+		Init: containerInfo.Init,
+		User: containerInfo.User,
+		WorkingDir: containerInfo.WorkingDir,
+		Hostname: containerInfo.Hostname,
+		Labels: containerInfo.Labels,
+		ExposedPorts: containerInfo.ExposedPorts,
+		StorageDriver: containerInfo.StorageDriver,
+	}
+	if spec.Resource == nil {
+
+		spec.Resource = &subsystems.ResourceConfig{}
+	}
+
+	return spec
+}
+
+//调用方式 ttdocker restart 容器名
+//运行中的容器先 stop 再 start
+func restartContainer(containerName string, timeout time.Duration) error {
+
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+
+	if container.HasStatus(containerInfo, container.RUNNING, container.PAUSED) {
+
+		if err := stopContainer(containerName, timeout); err != nil {
+
+			return err
+		}
+	}
+
+	return startContainer(containerName)
+}
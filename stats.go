@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"ttdocker/cgroups"
+	"ttdocker/container"
+	"ttdocker/network"
+)
+
+//两次采样之间的间隔
+const statsInterval = time.Second
+
+//一个容器在某一时刻的资源使用情况
+type containerStats struct {
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	CpuPercent    float64 `json:"cpuPercent"`
+	MemoryUsage   uint64  `json:"memoryUsage"`
+	MemoryLimit   uint64  `json:"memoryLimit"`
+	MemoryPercent float64 `json:"memoryPercent"`
+	NetRx         uint64  `json:"netRx"`
+	NetTx         uint64  `json:"netTx"`
+	BlockRead     uint64  `json:"blockRead"`
+	BlockWrite    uint64  `json:"blockWrite"`
+	Pids          uint64  `json:"pids"`
+
+	//计算 CPU 使用率需要前后两次采样的 CPU 时间和采样时刻
+	cpuUsage uint64
+	readTime time.Time
+}
+
+//调用方式 ttdocker stats [容器名...]
+//不指定容器名时显示所有运行中的容器, noStream 时只输出一次结果
+func statsContainers(containerNames []string, noStream bool, format string) error {
+
+	if format != "" && format != "json" {
+
+		return fmt.Errorf("unsupported format %s", format)
+	}
+
+	var containers []*container.ContainerInfo
+	if len(containerNames) == 0 {
+
+		for _, containerInfo := range getAllContainerInfos() {
+
+			if container.HasStatus(containerInfo, container.RUNNING, container.PAUSED) {
+
+				containers = append(containers, containerInfo)
+			}
+		}
+	} else {
+
+		for _, containerName := range containerNames {
+
+			containerInfo, err := getContainerInfoByName(containerName)
+			if err != nil {
+
+				return fmt.Errorf("get container %s info error %v", containerName, err)
+			}
+			containers = append(containers, containerInfo)
+		}
+	}
+
+	//CPU 使用率是两次采样之间 CPU 时间的增量除以经过的时间, 所以先采样一次
+	previous := map[string]*containerStats{}
+	for _, containerInfo := range containers {
+
+		previous[containerInfo.Id] = collectContainerStats(containerInfo)
+	}
+
+	for {
+
+		time.Sleep(statsInterval)
+
+		var current []*containerStats
+		for _, containerInfo := range containers {
+
+			stats := collectContainerStats(containerInfo)
+			if prev, ok := previous[containerInfo.Id]; ok && stats.cpuUsage >= prev.cpuUsage {
+
+				elapsed := stats.readTime.Sub(prev.readTime).Nanoseconds()
+				if elapsed > 0 {
+
+					stats.CpuPercent = float64(stats.cpuUsage-prev.cpuUsage) / float64(elapsed) * 100
+				}
+			}
+			previous[containerInfo.Id] = stats
+			current = append(current, stats)
+		}
+
+		if err := printContainerStats(current, format, !noStream); err != nil {
+
+			return err
+		}
+
+		if noStream {
+
+			return nil
+		}
+	}
+}
+
+//从容器的 cgroup 和网络端点中读取资源使用情况
+func collectContainerStats(containerInfo *container.ContainerInfo) *containerStats {
+
+	stats := &containerStats{
+		ID:       containerInfo.Id,
+		Name:     containerInfo.Name,
+		readTime: time.Now(),
+	}
+
+	if containerInfo.CgroupPath != "" {
+
+		cgroupStats, err := cgroups.NewCgroupManager(containerInfo.CgroupPath).GetStats()
+		if err != nil {
+
+			log.Debugf("get container %s cgroup stats error %v", containerInfo.Name, err)
+		} else {
+
+			stats.cpuUsage = cgroupStats.CpuUsage
+			stats.MemoryUsage = cgroupStats.MemoryUsage
+			stats.MemoryLimit = cgroupStats.MemoryLimit
+			stats.Pids = cgroupStats.PidsCurrent
+			stats.BlockRead = cgroupStats.BlkioRead
+			stats.BlockWrite = cgroupStats.BlkioWrite
+		}
+	}
+
+	//没有限制内存时, 以宿主机的总内存作为上限
+	if stats.MemoryLimit == 0 {
+
+		stats.MemoryLimit = hostMemoryTotal()
+	}
+	if stats.MemoryLimit > 0 {
+
+		stats.MemoryPercent = float64(stats.MemoryUsage) / float64(stats.MemoryLimit) * 100
+	}
+
+	endpoints, err := network.GetEndpoints(containerInfo.Id)
+	if err != nil {
+
+		log.Debugf("get container %s endpoints error %v", containerInfo.Name, err)
+	}
+	for _, ep := range endpoints {
+
+		rx, tx, err := network.EndpointStats(ep)
+		if err != nil {
+
+			log.Debugf("get endpoint %s stats error %v", ep.ID, err)
+			continue
+		}
+		stats.NetRx += rx
+		stats.NetTx += tx
+	}
+
+	return stats
+}
+
+func printContainerStats(stats []*containerStats, format string, clearScreen bool) error {
+
+	if format == "json" {
+
+		content, err := json.Marshal(stats)
+		if err != nil {
+
+			return err
+		}
+		fmt.Fprintln(os.Stdout, string(content))
+		return nil
+	}
+
+	//持续刷新时先清屏, 并把光标移动到左上角
+	if clearScreen {
+
+		fmt.Fprint(os.Stdout, "\033[2J\033[H")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "ID\tNAME\tCPU %\tMEM USAGE / LIMIT\tMEM %\tNET I/O\tBLOCK I/O\tPIDS\n")
+
+	for _, item := range stats {
+
+		fmt.Fprintf(w, "%s\t%s\t%.2f%%\t%s / %s\t%.2f%%\t%s / %s\t%s / %s\t%d\n",
+			item.ID,
+			item.Name,
+			item.CpuPercent,
+			formatBytes(item.MemoryUsage),
+			formatBytes(item.MemoryLimit),
+			item.MemoryPercent,
+			formatBytes(item.NetRx),
+			formatBytes(item.NetTx),
+			formatBytes(item.BlockRead),
+			formatBytes(item.BlockWrite),
+			item.Pids)
+	}
+
+	return w.Flush()
+}
+
+//宿主机的总内存
+func hostMemoryTotal() uint64 {
+
+	var info syscall.Sysinfo_t
+	if err := syscall.Sysinfo(&info); err != nil {
+
+		return 0
+	}
+
+	return uint64(info.Totalram) * uint64(info.Unit)
+}
+
+//把字节数转换成 1.5MiB 这种便于阅读的形式
+func formatBytes(size uint64) string {
+
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+
+		value /= 1024
+		i++
+	}
+
+	return fmt.Sprintf("%.2f%s", value, units[i])
+}
@@ -6,63 +6,170 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"io/ioutil"
 	"os"
+	"ttdocker/cgroups"
 	"ttdocker/container"
 	"strconv"
 	"syscall"
+	"time"
 )
 
-func stopContainer(containerName string){
+//先发送 SIGTERM, 容器进程在 timeout 内没有退出再发送 SIGKILL
+//容器进程真正退出之后才释放 cgroup, 并把容器改为 stopped 状态
+func stopContainer(containerName string, timeout time.Duration) error {
 
-	//根据容器名获取对应的主进程 PID
-	pid, err := GetContainerPidByName(containerName)
-	if err != nil {
+	//根据容器名获取对应信息对象
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil{
 
-		log.Errorf("get container pid by name %s error %v", containerName, err)
-		return
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+
+	if err := container.CheckTransition(containerInfo, container.STOP); err != nil {
+
+		return err
+	}
+
+	//先记录容器是被手动停止的, 这样容器退出之后 shim 不会按照重启策略重启容器
+	containerInfo.ManuallyStopped = true
+	if err := writeContainerInfo(containerInfo); err != nil {
+
+		return fmt.Errorf("update container %s info error %v", containerName, err)
+	}
+
+	//容器已经退出, 例如正在等待重启, 这时没有进程需要停止, 只修改状态
+	if containerInfo.Status == container.Exit {
+
+		containerInfo.Status = container.STOP
+		return writeContainerInfo(containerInfo)
 	}
 
 	//将 string 类型的PID转换为 int 类型
-	pidInt, err := strconv.Atoi(pid)
+	pidInt, err := strconv.Atoi(containerInfo.Pid)
 	if err != nil {
 
-		log.Errorf("conver pid from string to int error %v", err)
-		return
+		return fmt.Errorf("conver pid from string to int error %v", err)
 	}
 
 	//调用系统diaoyong kill 可以发送信号给进程, 通过传递syscall.SIGTERM 信号，去杀掉容器主进程
-	if err := syscall.Kill(pidInt, syscall.SIGTERM); err != nil {
+	//create 之后还没有 start 的容器停在 init 管道上, 同样会被 SIGTERM 杀掉
+	//ESRCH 说明容器进程已经不在了, 但是没有 shim 记录退出, 例如 shim 被杀掉了, 这时直接改为停止状态
+	if err := syscall.Kill(pidInt, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
 
-		log.Errorf("stop container %s error %v", containerName, err)
-		return
+		return fmt.Errorf("stop container %s error %v", containerName, err)
 	}
 
-	//根据容器名获取对应信息对象
-	containerInfo, err := getContainerInfoByName(containerName)
-	if err != nil{
+	//被冻结的进程无法处理信号, 发送信号之后需要解冻, 让进程处理 SIGTERM 退出
+	if containerInfo.Status == container.PAUSED {
+
+		if err := cgroups.NewCgroupManager(containerInfo.CgroupPath).Thaw(); err != nil {
+
+			log.Errorf("thaw container %s error %v", containerName, err)
+		}
+	}
 
-		log.Errorf("get container %s info error %v",err)
+	//容器的 init 进程是 PID namespace 中的 1 号进程, 没有注册处理函数的信号会被内核忽略
+	//所以很多程序收不到 SIGTERM, 超时之后发送无法忽略的 SIGKILL
+	if !waitProcessExit(pidInt, timeout) {
+
+		log.Infof("container %s did not exit within %v, killing it", containerName, timeout)
+		if err := syscall.Kill(pidInt, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+
+			return fmt.Errorf("kill container %s error %v", containerName, err)
+		}
+		//进程还在说明容器没有停下来, 不修改 config.json 中的状态
+		if !waitProcessExit(pidInt, 10*time.Second) {
+
+			return fmt.Errorf("container %s process %d is still alive", containerName, pidInt)
+		}
+	}
+
+	//等容器进程真正退出之后再释放 cgroup, 否则 cgroup 中还有进程, 无法删除
+	if err := destroyContainerCgroup(containerInfo); err != nil {
+
+		log.Errorf("destroy container %s cgroup error %v", containerName, err)
+	}
+
+	//容器退出时 shim 会写入退出码和退出时间, 等 shim 写完之后再读取, 避免覆盖掉 shim 写入的内容
+	//也避免 restart 重新启动容器之后, 旧的 shim 才把容器改为停止状态
+	finishedTime := containerInfo.FinishedTime
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+
+		if latest, err := getContainerInfoByName(containerName); err == nil {
+
+			containerInfo = latest
+		}
+		if containerInfo.FinishedTime != finishedTime || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
 
 	//至此，容器进程已经被kill， 所以下面需要修改容器状态，PID可以置为空
 	containerInfo.Status = container.STOP
 	containerInfo.Pid = " "
 
-	//将修改后的信息序列化成 json 的字符串
+	return writeContainerInfo(containerInfo)
+}
+
+//每隔 100ms 检查一次进程是否还存在, 进程在 timeout 内退出返回 true
+func waitProcessExit(pid int, timeout time.Duration) bool {
+
+	deadline := time.Now().Add(timeout)
+	for checkPid(pid) {
+
+		if time.Now().After(deadline) {
+
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return true
+}
+
+//释放容器记录的 cgroup, 重复调用不会报错
+func destroyContainerCgroup(containerInfo *container.ContainerInfo) error {
+
+	if containerInfo.CgroupPath == "" {
+
+		return nil
+	}
+
+	return cgroups.NewCgroupManager(containerInfo.CgroupPath).Destroy()
+}
+
+//将容器信息序列化成 json 的字符串, 重新写入 config.json 覆盖原来的信息
+func writeContainerInfo(containerInfo *container.ContainerInfo) error {
+
 	newContentBytes, err := json.Marshal(containerInfo)
 	if err != nil {
 
-		log.Errorf("Json marshal %s error %v", containerName, err)
-		return
+		return fmt.Errorf("json marshal %s error %v", containerInfo.Name, err)
 	}
 
-	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	//shim 和命令行会同时读写 config.json, 先写到临时文件再 rename, 读的一方不会读到写了一半的文件
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Name)
 	configFilePath := dirURL + container.ConfigName
-	//重新写入新的数据 覆盖原来的信息
-	if err := ioutil.WriteFile(configFilePath, newContentBytes, 0622); err != nil {
+	tmpFile, err := ioutil.TempFile(dirURL, container.ConfigName + ".")
+	if err != nil {
+
+		return fmt.Errorf("create temp file in %s error %v", dirURL, err)
+	}
+	_, err = tmpFile.Write(newContentBytes)
+	tmpFile.Close()
+	if err != nil {
+
+		os.Remove(tmpFile.Name())
+		return fmt.Errorf("write file %s error %v", tmpFile.Name(), err)
+	}
+	if err := os.Rename(tmpFile.Name(), configFilePath); err != nil {
 
-		log.Errorf("write file %s error", configFilePath, err)
+		os.Remove(tmpFile.Name())
+		return fmt.Errorf("rename %s error %v", tmpFile.Name(), err)
 	}
 
+	return nil
 }
 
 //调用方式 mydocker stop 容器名
@@ -75,7 +182,7 @@ func getContainerInfoByName(containerName string) (* container.ContainerInfo, er
 	contentBytes, err := ioutil.ReadFile(configFilePath)
 	if err != nil {
 
-		log.Errorf("Read file % error %v", configFilePath, err)
+		log.Errorf("Read file %s error %v", configFilePath, err)
 		return nil, err
 	}
 
@@ -91,21 +198,36 @@ func getContainerInfoByName(containerName string) (* container.ContainerInfo, er
 }
 
 
-func removeContainer(containerName string){
+func removeContainer(containerName string) error {
 
 	//根据荣启明获取容器对应的信息
 	containerInfo, err := getContainerInfoByName(containerName)
 	if err != nil {
 
-		log.Errorf("get container %s info error %v", containerName, err)
-		return
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+
+	//只删除还没有 start, 已经停止或者已经退出的容器
+	if err := container.CheckTransition(containerInfo, container.REMOVED); err != nil {
+
+		return err
+	}
+
+	//还没有 start 的容器进程停在 init 管道上, 先杀掉
+	if containerInfo.Status == container.CREATED {
+
+		if pid, err := strconv.Atoi(containerInfo.Pid); err == nil {
+
+			syscall.Kill(pid, syscall.SIGKILL)
+			waitProcessExit(pid, 10*time.Second)
+		}
 	}
 
-	//只删除处于停止状态的容器
-	if containerInfo.Status != container.STOP{
+	//释放容器的 cgroup, 容器退出或者 stop 时可能已经释放过了
+	//容器进程已经不在了, 释放失败只记录下来, 不影响删除容器记录和工作目录
+	if err := destroyContainerCgroup(containerInfo); err != nil {
 
-		log.Errorf("couldn't remove running container")
-		return
+		log.Warnf("destroy container %s cgroup error %v", containerName, err)
 	}
 
 	//找到对应存储容器信息的文件路径
@@ -113,11 +235,11 @@ func removeContainer(containerName string){
 	//将所有信息包括子目录都一出
 	if err := os.RemoveAll(dirURL); err != nil {
 
-		log.Errorf("remove file %s error %v", dirURL, err)
-		return
+		return fmt.Errorf("remove file %s error %v", dirURL, err)
 	}
 
 	//删除工作环境
-	container.DeleteWorkSpace(containerInfo.Volume, containerName)
+	container.DeleteWorkSpace(containerInfo.Volume, containerName, containerInfo.StorageDriver)
+	return nil
 }
 
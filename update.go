@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"ttdocker/cgroups"
+	"ttdocker/cgroups/subsystems"
+	"ttdocker/container"
+)
+
+//调用方式 ttdocker update --m 1g --cpushare 512 容器名
+//修改运行中容器的资源限制, 并把新的限制写回容器的 config.json
+func updateContainer(containerName string, update *subsystems.ResourceConfig) error {
+
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+
+	//在原有的限制上合并本次指定的参数, 然后重新校验
+	res := mergeResourceConfig(containerInfo.Resource, update)
+	if err := res.Validate(); err != nil {
+
+		return err
+	}
+
+	//只有已经创建, 运行中和暂停的容器才有 cgroup, 其余状态只更新记录
+	if container.HasStatus(containerInfo, container.CREATED, container.RUNNING, container.PAUSED) {
+
+		if containerInfo.CgroupPath == "" {
+
+			return fmt.Errorf("container %s has no cgroup recorded", containerName)
+		}
+
+		cgroupManager := cgroups.NewCgroupManager(containerInfo.CgroupPath)
+		if err := cgroupManager.Set(res); err != nil {
+
+			return fmt.Errorf("update container %s resource error %v", containerName, err)
+		}
+	}
+
+	containerInfo.Resource = res
+	if err := writeContainerInfo(containerInfo); err != nil {
+
+		return err
+	}
+
+	log.Infof("container %s resource updated", containerName)
+	return nil
+}
+
+//用 update 中非空的字段覆盖 old 中的字段, 返回一个新的配置
+func mergeResourceConfig(old *subsystems.ResourceConfig, update *subsystems.ResourceConfig) *subsystems.ResourceConfig {
+
+	res := &subsystems.ResourceConfig{}
+	if old != nil {
+
+		*res = *old
+	}
+
+	mergeString := func(dst *string, src string) {
+
+		if src != "" {
+			*dst = src
+		}
+	}
+
+	mergeString(&res.MemoryLimit, update.MemoryLimit)
+	mergeString(&res.MemorySwap, update.MemorySwap)
+	mergeString(&res.MemoryReservation, update.MemoryReservation)
+	mergeString(&res.CpuShare, update.CpuShare)
+	mergeString(&res.CpuSet, update.CpuSet)
+	mergeString(&res.PidsLimit, update.PidsLimit)
+	mergeString(&res.BlkioWeight, update.BlkioWeight)
+
+	//--cpus 和 --cpu-quota/--cpu-period 互斥, 指定了其中一种就丢弃原来的另一种
+	if update.Cpus != "" {
+
+		res.Cpus = update.Cpus
+		res.CpuQuota = ""
+		res.CpuPeriod = ""
+	} else if update.CpuQuota != "" || update.CpuPeriod != "" {
+
+		res.Cpus = ""
+		mergeString(&res.CpuQuota, update.CpuQuota)
+		mergeString(&res.CpuPeriod, update.CpuPeriod)
+	}
+
+	return res
+}